@@ -0,0 +1,29 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// appsJSONHandler serves values of all apps, or of the app given by the
+// app_name parameter, as JSON. values is called with the parameter, empty for
+// all apps, and reports false when the app is unknown.
+type appsJSONHandler struct {
+	name   string
+	values func(appName string) (interface{}, bool)
+}
+
+func (h *appsJSONHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	values, ok := h.values(r.URL.Query().Get("app_name"))
+	if !ok {
+		http.Error(w, "Not Found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(values); err != nil {
+		log.Printf("Failed to write %s: %v\n", h.name, err)
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+const redactedValue = "REDACTED"
+
+// htpasswdFile holds users and bcrypt password hashes in the format written
+// by `htpasswd -B`. Successful checks are cached because bcrypt is
+// deliberately slow and Logplex sends many requests with the same credentials.
+// Passwords of unknown users are compared with dummyHash, so that a check
+// takes as long as for a known user and does not reveal which users exist.
+type htpasswdFile struct {
+	mutex     sync.RWMutex
+	hashes    map[string][]byte
+	dummyHash []byte
+	cache     map[[sha256.Size]byte]bool
+}
+
+func newHtpasswdFile() *htpasswdFile {
+	return &htpasswdFile{
+		hashes: make(map[string][]byte),
+		cache:  make(map[[sha256.Size]byte]bool),
+	}
+}
+
+func (f *htpasswdFile) Load(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	hashes := make(map[string][]byte)
+	maxCost := bcrypt.MinCost
+
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber = lineNumber + 1
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, ":", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return fmt.Errorf("%s:%d: expected user:hash", path, lineNumber)
+		}
+
+		cost, err := bcrypt.Cost([]byte(parts[1]))
+		if err != nil {
+			return fmt.Errorf("%s:%d: password of %s is not a bcrypt hash", path, lineNumber, parts[0])
+		}
+
+		if cost > maxCost {
+			maxCost = cost
+		}
+
+		hashes[parts[0]] = []byte(parts[1])
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	dummyHash, err := bcrypt.GenerateFromPassword([]byte(redactedValue), maxCost)
+	if err != nil {
+		return err
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.hashes = hashes
+	f.dummyHash = dummyHash
+	f.cache = make(map[[sha256.Size]byte]bool)
+
+	return nil
+}
+
+func (f *htpasswdFile) Check(user string, password string) bool {
+	f.mutex.RLock()
+	hash, ok := f.hashes[user]
+	if !ok {
+		dummyHash := f.dummyHash
+		f.mutex.RUnlock()
+
+		if dummyHash != nil {
+			bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		}
+
+		return false
+	}
+
+	key := sha256.Sum256(bytes.Join([][]byte{[]byte(user), []byte(password), hash}, []byte{0}))
+	cached := f.cache[key]
+	f.mutex.RUnlock()
+
+	if cached {
+		return true
+	}
+
+	if bcrypt.CompareHashAndPassword(hash, []byte(password)) != nil {
+		return false
+	}
+
+	f.mutex.Lock()
+	f.cache[key] = true
+	f.mutex.Unlock()
+
+	return true
+}
+
+// redactURL removes user info and values of secret query parameters from u,
+// so it can be logged.
+func redactURL(u *url.URL, secretParams ...string) string {
+	redacted := *u
+
+	if redacted.User != nil {
+		redacted.User = url.User(redacted.User.Username())
+	}
+
+	query := redacted.Query()
+	for _, name := range secretParams {
+		if name != "" && query.Get(name) != "" {
+			query.Set(name, redactedValue)
+		}
+	}
+	redacted.RawQuery = query.Encode()
+
+	return redacted.String()
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"heroku-logs-exporter/metrics"
+)
+
+var (
+	labelNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+	drainAuthFailuresCount = metrics.NewCounterVec(
+		"heroku_logs_exporter_drain_auth_failures_total",
+		"Drain requests and syslog connections rejected because they could not be mapped to an app.",
+		[]string{"reason"},
+	)
+)
+
+// drainConfig maps a drain to the only app it may report as. A drain is
+// identified either by its Logplex-Drain-Token or by a per-app secret passed
+// in the token query parameter together with app_name.
+type drainConfig struct {
+	AppName    string            `json:"app_name"`
+	DrainToken string            `json:"drain_token"`
+	Secret     string            `json:"secret"`
+	Labels     map[string]string `json:"labels"`
+}
+
+type drainsFile struct {
+	Drains []drainConfig `json:"drains"`
+}
+
+type drainRegistry struct {
+	mutex      sync.RWMutex
+	byToken    map[string]*drainConfig
+	byApp      map[string][]*drainConfig
+	labelNames []string
+}
+
+func newDrainRegistry() *drainRegistry {
+	return &drainRegistry{
+		byToken: make(map[string]*drainConfig),
+		byApp:   make(map[string][]*drainConfig),
+	}
+}
+
+func (r *drainRegistry) Load(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var file drainsFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	byToken := make(map[string]*drainConfig)
+	byApp := make(map[string][]*drainConfig)
+	labelNames := make(map[string]bool)
+
+	for i := range file.Drains {
+		drain := &file.Drains[i]
+
+		if drain.AppName == "" {
+			return fmt.Errorf("%s: drain %d has no app_name", path, i)
+		}
+
+		if drain.DrainToken == "" && drain.Secret == "" {
+			return fmt.Errorf("%s: drain %d for %s needs drain_token or secret", path, i, drain.AppName)
+		}
+
+		if drain.DrainToken != "" {
+			if _, ok := byToken[drain.DrainToken]; ok {
+				return fmt.Errorf("%s: duplicate drain_token for %s", path, drain.AppName)
+			}
+
+			byToken[drain.DrainToken] = drain
+		}
+
+		for name := range drain.Labels {
+			if !labelNamePattern.MatchString(name) || name == "app_name" {
+				return fmt.Errorf("%s: invalid label name %q for %s", path, name, drain.AppName)
+			}
+
+			labelNames[name] = true
+		}
+
+		byApp[drain.AppName] = append(byApp[drain.AppName], drain)
+	}
+
+	sortedLabelNames := make([]string, 0, len(labelNames))
+	for name := range labelNames {
+		sortedLabelNames = append(sortedLabelNames, name)
+	}
+	sort.Strings(sortedLabelNames)
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.byToken = byToken
+	r.byApp = byApp
+	r.labelNames = sortedLabelNames
+
+	return nil
+}
+
+func (r *drainRegistry) AppForDrainToken(drainToken string) (string, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	drain, ok := r.byToken[drainToken]
+	if !ok {
+		return "", false
+	}
+
+	return drain.AppName, true
+}
+
+func (r *drainRegistry) CheckSecret(appName string, secret string) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	if secret == "" {
+		return false
+	}
+
+	for _, drain := range r.byApp[appName] {
+		if drain.Secret != "" && subtle.ConstantTimeCompare([]byte(drain.Secret), []byte(secret)) == 1 {
+			return true
+		}
+	}
+
+	return false
+}
+
+// The registry exposes extra labels of each app as an info metric, so they can
+// be joined to other metrics by app_name. Label names change with the drains
+// file, hence the collector is unchecked and describes nothing.
+func (r *drainRegistry) Describe(ch chan<- *prometheus.Desc) {
+}
+
+func (r *drainRegistry) Collect(ch chan<- prometheus.Metric) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	desc := prometheus.NewDesc(
+		"heroku_logs_exporter_app_info",
+		"Extra labels of apps configured in the drains file.",
+		append([]string{"app_name"}, r.labelNames...),
+		nil,
+	)
+
+	for appName, drains := range r.byApp {
+		values := []string{appName}
+		for _, name := range r.labelNames {
+			value := ""
+			for _, drain := range drains {
+				if drainValue, ok := drain.Labels[name]; ok {
+					value = drainValue
+				}
+			}
+
+			values = append(values, value)
+		}
+
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, 1, values...)
+	}
+}
+
+// authenticateDrain returns the app name a drain request may report as.
+// Basic auth credentials are required first when a basic auth file is set.
+// Without a drains file the shared token parameter and the app_name parameter
+// are used as they are.
+func authenticateDrain(r *http.Request) (string, string, bool) {
+	if drainUsers != nil {
+		user, password, ok := r.BasicAuth()
+		if !ok {
+			return "", "missing_basic_auth", false
+		}
+
+		if !drainUsers.Check(user, password) {
+			return "", "invalid_basic_auth", false
+		}
+	}
+
+	query := r.URL.Query()
+	token := query.Get(*logsTokenParamName)
+
+	if drains == nil {
+		if *logsTokenParamName != "" && *logsTokenParamValue != "" {
+			if subtle.ConstantTimeCompare([]byte(*logsTokenParamValue), []byte(token)) != 1 {
+				return "", "token_mismatch", false
+			}
+		}
+
+		return query.Get("app_name"), "", true
+	}
+
+	drainToken := r.Header.Get("Logplex-Drain-Token")
+	if appName, ok := drains.AppForDrainToken(drainToken); ok {
+		return appName, "", true
+	}
+
+	appName := query.Get("app_name")
+	if drains.CheckSecret(appName, token) {
+		return appName, "", true
+	}
+
+	switch {
+	case token != "":
+		return "", "invalid_secret", false
+	case drainToken != "":
+		return "", "unknown_drain_token", false
+	}
+
+	return "", "missing_credentials", false
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"heroku-logs-exporter/metrics"
+)
+
+var (
+	errUnsupportedContentEncoding = errors.New("unsupported content encoding")
+	errCorruptBody                = errors.New("corrupt compressed body")
+	errRequestBodyTooLarge        = errors.New("request body too large")
+	errDecompressedBodyTooLarge   = errors.New("decompressed body too large")
+)
+
+var (
+	receivedBytesCount = metrics.NewCounterVec(
+		"heroku_logs_exporter_received_bytes_total",
+		"Bytes of drain request bodies as received, before decompression.",
+		[]string{"app_name", "encoding"},
+	)
+	receivedUncompressedBytesCount = metrics.NewCounterVec(
+		"heroku_logs_exporter_received_uncompressed_bytes_total",
+		"Bytes of drain request bodies after decompression.",
+		[]string{"app_name"},
+	)
+	limitViolationsCount = metrics.NewCounterVec(
+		"heroku_logs_exporter_limit_violations_total",
+		"Drain requests and messages exceeding configured limits. Messages over the maximum length are truncated.",
+		[]string{"app_name", "limit"},
+	)
+)
+
+type countingReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (r *countingReader) Read(b []byte) (int, error) {
+	n, err := r.reader.Read(b)
+	r.count += int64(n)
+	return n, err
+}
+
+type maxSizeReader struct {
+	reader    io.Reader
+	remaining int64
+	err       error
+}
+
+func (r *maxSizeReader) Read(b []byte) (int, error) {
+	if r.remaining <= 0 {
+		// Distinguish a body of exactly the allowed size from a larger one.
+		var probe [1]byte
+		if n, _ := r.reader.Read(probe[:]); n > 0 {
+			return 0, r.err
+		}
+
+		return 0, io.EOF
+	}
+
+	if int64(len(b)) > r.remaining {
+		b = b[:r.remaining]
+	}
+
+	n, err := r.reader.Read(b)
+	r.remaining -= int64(n)
+	return n, err
+}
+
+// sourceReader remembers the last error of the request body.
+type sourceReader struct {
+	reader io.Reader
+	err    error
+}
+
+func (r *sourceReader) Read(b []byte) (int, error) {
+	n, err := r.reader.Read(b)
+	if err != nil {
+		r.err = err
+	}
+
+	return n, err
+}
+
+// corruptBody tells errors of a decompressor apart from errors of the request
+// body it reads from. Decompressor errors without a failed read of the body
+// mean that the body itself is not a valid stream and wrap errCorruptBody.
+func corruptBody(source *sourceReader, err error) error {
+	if err == nil || err == io.EOF || source.err != nil && source.err != io.EOF {
+		return err
+	}
+
+	return fmt.Errorf("%w: %v", errCorruptBody, err)
+}
+
+// noEOF turns io.EOF into io.ErrUnexpectedEOF, an empty body is not a valid
+// compressed stream.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+
+	return err
+}
+
+type decompressedReader struct {
+	reader io.Reader
+	source *sourceReader
+}
+
+func (r *decompressedReader) Read(b []byte) (int, error) {
+	n, err := r.reader.Read(b)
+	return n, corruptBody(r.source, err)
+}
+
+// decodeDrainBody wraps body according to Content-Encoding. Decompressed
+// bodies are capped at maxSize bytes to protect against decompression bombs,
+// a maxSize of 0 disables the cap.
+func decodeDrainBody(body io.Reader, contentEncoding string, maxSize int64) (io.Reader, string, error) {
+	encoding := strings.ToLower(strings.TrimSpace(contentEncoding))
+
+	source := &sourceReader{reader: body}
+
+	var decoded io.Reader
+	switch encoding {
+	case "", "identity":
+		return body, "identity", nil
+	case "gzip", "x-gzip":
+		reader, err := gzip.NewReader(source)
+		if err != nil {
+			return nil, "gzip", corruptBody(source, noEOF(err))
+		}
+
+		encoding = "gzip"
+		decoded = reader
+	case "deflate":
+		reader, err := zlib.NewReader(source)
+		if err != nil {
+			return nil, encoding, corruptBody(source, noEOF(err))
+		}
+
+		decoded = reader
+	default:
+		return nil, "unsupported", fmt.Errorf("%w: %q", errUnsupportedContentEncoding, contentEncoding)
+	}
+
+	decoded = &decompressedReader{decoded, source}
+
+	if maxSize > 0 {
+		decoded = &maxSizeReader{decoded, maxSize, errDecompressedBodyTooLarge}
+	}
+
+	return decoded, encoding, nil
+}
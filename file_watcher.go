@@ -0,0 +1,44 @@
+package main
+
+import (
+	"log"
+	"os"
+	"time"
+)
+
+// watchFile calls reload whenever modification time or size of path changes.
+// Failed reloads are logged and the previously loaded content stays in use.
+func watchFile(path string, interval time.Duration, reload func(path string) error) {
+	if interval <= 0 {
+		return
+	}
+
+	modTime, size := fileVersion(path)
+
+	go func() {
+		for range time.Tick(interval) {
+			newModTime, newSize := fileVersion(path)
+			if newModTime.Equal(modTime) && newSize == size {
+				continue
+			}
+
+			modTime, size = newModTime, newSize
+
+			if err := reload(path); err != nil {
+				log.Printf("Failed to reload %s: %v\n", path, err)
+				continue
+			}
+
+			log.Printf("Reloaded %s\n", path)
+		}
+	}()
+}
+
+func fileVersion(path string) (time.Time, int64) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, -1
+	}
+
+	return info.ModTime(), info.Size()
+}
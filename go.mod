@@ -2,4 +2,9 @@ module heroku-logs-exporter
 
 go 1.16
 
-require github.com/prometheus/client_golang v1.11.0
+require (
+	github.com/prometheus/client_golang v1.11.0
+	github.com/prometheus/client_model v0.2.0
+	github.com/prometheus/common v0.26.0
+	golang.org/x/crypto v0.0.0-20210921155107-089bfa567519
+)
@@ -0,0 +1,45 @@
+package herokuLog
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Lines printed by `heroku logs`, e.g.
+// 2026-10-18T10:00:00.000000+00:00 app[web.1]: Started GET "/"
+
+var ErrInvalidCLILog = errors.New("invalid heroku logs line")
+
+func ParseHerokuCLILog(appName string, line string) (*HerokuLog, error) {
+	separator := strings.IndexByte(line, ' ')
+	if separator < 0 {
+		return nil, fmt.Errorf("%w: missing timestamp", ErrInvalidCLILog)
+	}
+
+	timestamp, err := time.Parse(time.RFC3339Nano, line[:separator])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q", ErrInvalidTimestamp, line[:separator])
+	}
+
+	rest := line[separator+1:]
+
+	end := strings.Index(rest, "]:")
+	if end < 0 {
+		return nil, fmt.Errorf("%w: missing source", ErrInvalidCLILog)
+	}
+
+	start := strings.IndexByte(rest[:end], '[')
+	if start <= 0 {
+		return nil, fmt.Errorf("%w: missing dyno", ErrInvalidCLILog)
+	}
+
+	return &HerokuLog{
+		AppName:   appName,
+		Timestamp: timestamp,
+		Source:    rest[:start],
+		Dyno:      rest[start+1 : end],
+		Line:      strings.TrimPrefix(rest[end+2:], " "),
+	}, nil
+}
@@ -1,42 +1,90 @@
 package herokuLog
 
 import (
-	"strings"
+	"time"
+)
+
+// https://datatracker.ietf.org/doc/html/rfc5424#section-6
+
+type LineFormat int
+
+const (
+	LineFormatLogfmt LineFormat = iota
+	LineFormatJSON
 )
 
 type HerokuLog struct {
 	AppName string
 
-	Unk1   string
-	Unk2   string
-	Time   string
-	Host   string
-	Source string
-	Dyno   string
+	Priority  int
+	Facility  int
+	Severity  int
+	Version   int
+	Timestamp time.Time
+	Hostname  string
+	Source    string
+	Dyno      string
+	MsgID     string
+
+	StructuredData StructuredData
 
 	Line string
 
 	lineValuesParsed bool
+	lineFormat       LineFormat
+	lineKeys         []string
 	lineValues       map[string]string
 }
 
-func ParseHerokuLog(appName string, line string) *HerokuLog {
-	parts := strings.SplitN(line, " - ", 2)
+func ParseHerokuLog(appName string, line string) (*HerokuLog, error) {
+	p := &syslogParser{line, 0}
 
-	headerParts := strings.Split(parts[0], " ")
+	priority, version, err := p.parsePriorityAndVersion()
+	if err != nil {
+		return nil, err
+	}
 
-	return &HerokuLog{
-		appName,
-		headerParts[0],
-		headerParts[1],
-		headerParts[2],
-		headerParts[3],
-		headerParts[4],
-		headerParts[5],
-		parts[1],
-		false,
-		nil,
+	timestamp, err := p.parseTimestamp()
+	if err != nil {
+		return nil, err
+	}
+
+	hostname, err := p.parseHeaderField("hostname")
+	if err != nil {
+		return nil, err
+	}
+
+	source, err := p.parseHeaderField("app-name")
+	if err != nil {
+		return nil, err
+	}
+
+	dyno, err := p.parseHeaderField("procid")
+	if err != nil {
+		return nil, err
+	}
+
+	msgID, err := p.parseHeaderField("msgid")
+	if err != nil {
+		return nil, err
 	}
+
+	structuredData := p.parseStructuredData()
+
+	return &HerokuLog{
+		AppName:        appName,
+		Priority:       priority,
+		Facility:       priority / 8,
+		Severity:       priority % 8,
+		Version:        version,
+		Timestamp:      timestamp,
+		Hostname:       hostname,
+		Source:         source,
+		Dyno:           dyno,
+		MsgID:          msgID,
+		StructuredData: structuredData,
+		Line:           p.parseMessage(),
+	}, nil
 }
 
 func (l *HerokuLog) parseLineValues() {
@@ -44,18 +92,42 @@ func (l *HerokuLog) parseLineValues() {
 		return
 	}
 
-	tokens := strings.Split(l.Line, " ")
+	pairs, ok := parseJSONValues(l.Line)
+	if ok {
+		l.lineFormat = LineFormatJSON
+	} else {
+		pairs = parseLogfmt(l.Line)
+		l.lineFormat = LineFormatLogfmt
+	}
+
 	l.lineValues = make(map[string]string)
-	for _, pair := range tokens {
-		if strings.Contains(pair, "=") {
-			parts := strings.Split(pair, "=")
-			l.lineValues[parts[0]] = parts[1]
+	for _, pair := range pairs {
+		if _, ok := l.lineValues[pair.key]; !ok {
+			l.lineKeys = append(l.lineKeys, pair.key)
 		}
+
+		l.lineValues[pair.key] = pair.value
 	}
 
 	l.lineValuesParsed = true
 }
 
+// LineFormat returns whether line values were read from a JSON object or
+// from logfmt pairs.
+func (l *HerokuLog) LineFormat() LineFormat {
+	l.parseLineValues()
+
+	return l.lineFormat
+}
+
+// Keys returns keys of the line values in order of their first occurrence.
+// Value of a duplicate key is the last one in the line.
+func (l *HerokuLog) Keys() []string {
+	l.parseLineValues()
+
+	return l.lineKeys
+}
+
 func (l *HerokuLog) Value(key string) (string, bool) {
 	l.parseLineValues()
 
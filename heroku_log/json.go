@@ -0,0 +1,92 @@
+package herokuLog
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+)
+
+var errJSONNotObject = errors.New("json value is not an object")
+
+// parseJSONValues flattens a JSON object into pairs keyed by dotted paths,
+// e.g. {"http":{"status":200}} becomes http.status=200 and array elements are
+// keyed by their index. Keys keep their original order, null values are
+// omitted and numbers keep their original text.
+func parseJSONValues(line string) ([]valuePair, bool) {
+	trimmed := strings.TrimSpace(line)
+	if !strings.HasPrefix(trimmed, "{") || !strings.HasSuffix(trimmed, "}") {
+		return nil, false
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(trimmed))
+	decoder.UseNumber()
+
+	var pairs []valuePair
+	if err := flattenJSON(decoder, "", &pairs); err != nil {
+		return nil, false
+	}
+
+	if _, err := decoder.Token(); err != io.EOF {
+		return nil, false
+	}
+
+	return pairs, true
+}
+
+func flattenJSON(decoder *json.Decoder, path string, pairs *[]valuePair) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+
+	switch value := token.(type) {
+	case json.Delim:
+		if value == '{' {
+			for decoder.More() {
+				keyToken, err := decoder.Token()
+				if err != nil {
+					return err
+				}
+
+				if err := flattenJSON(decoder, joinJSONPath(path, keyToken.(string)), pairs); err != nil {
+					return err
+				}
+			}
+		} else {
+			if path == "" {
+				return errJSONNotObject
+			}
+
+			for i := 0; decoder.More(); i++ {
+				if err := flattenJSON(decoder, joinJSONPath(path, strconv.Itoa(i)), pairs); err != nil {
+					return err
+				}
+			}
+		}
+
+		_, err := decoder.Token()
+		return err
+	case string:
+		*pairs = append(*pairs, valuePair{path, value})
+	case json.Number:
+		*pairs = append(*pairs, valuePair{path, value.String()})
+	case bool:
+		*pairs = append(*pairs, valuePair{path, strconv.FormatBool(value)})
+	}
+
+	if path == "" {
+		return errJSONNotObject
+	}
+
+	return nil
+}
+
+func joinJSONPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
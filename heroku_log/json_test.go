@@ -0,0 +1,100 @@
+package herokuLog
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseJSONValues(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		expected []valuePair
+		ok       bool
+	}{
+		{
+			name: "flat object",
+			line: `{"level":"info","request_id":"abc-123","duration_ms":12.50,"cached":false}`,
+			expected: []valuePair{
+				{"level", "info"},
+				{"request_id", "abc-123"},
+				{"duration_ms", "12.50"},
+				{"cached", "false"},
+			},
+			ok: true,
+		},
+		{
+			name: "nested objects",
+			line: ` {"http":{"status":200,"request":{"method":"GET"}},"msg":"done"} `,
+			expected: []valuePair{
+				{"http.status", "200"},
+				{"http.request.method", "GET"},
+				{"msg", "done"},
+			},
+			ok: true,
+		},
+		{
+			name: "arrays",
+			line: `{"tags":["a","b"],"spans":[{"id":1},{"id":2}],"empty":[]}`,
+			expected: []valuePair{
+				{"tags.0", "a"},
+				{"tags.1", "b"},
+				{"spans.0.id", "1"},
+				{"spans.1.id", "2"},
+			},
+			ok: true,
+		},
+		{
+			name: "null values are omitted",
+			line: `{"user":null,"a":1}`,
+			expected: []valuePair{
+				{"a", "1"},
+			},
+			ok: true,
+		},
+		{
+			name:     "empty object",
+			line:     `{}`,
+			expected: nil,
+			ok:       true,
+		},
+		{
+			name: "top level array",
+			line: `[{"a":1}]`,
+		},
+		{
+			name: "top level string",
+			line: `"{}"`,
+		},
+		{
+			name: "text starting with a brace",
+			line: `{not json}`,
+		},
+		{
+			name: "trailing value",
+			line: `{"a":1} {"b":2}`,
+		},
+		{
+			name: "truncated object",
+			line: `{"a":{"b":1}`,
+		},
+		{
+			name: "logfmt line",
+			line: `at=info method=GET`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pairs, ok := parseJSONValues(test.line)
+
+			if ok != test.ok {
+				t.Fatalf("expected ok %v, got %v", test.ok, ok)
+			}
+
+			if !reflect.DeepEqual(pairs, test.expected) {
+				t.Errorf("expected %q, got %q", test.expected, pairs)
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+package herokuLog
+
+import (
+	"strings"
+)
+
+// https://brandur.org/logfmt
+
+type valuePair struct {
+	key   string
+	value string
+}
+
+// parseLogfmt splits line into key/value pairs in their original order.
+// Values may be double-quoted with backslash escapes, unquoted values run
+// until the next space and may contain "=". Bare keys get an empty value.
+// Malformed input never fails, unterminated quotes run to the end of line.
+func parseLogfmt(line string) []valuePair {
+	var pairs []valuePair
+
+	position := 0
+	for position < len(line) {
+		if line[position] == ' ' || line[position] == '\t' {
+			position++
+			continue
+		}
+
+		start := position
+		for position < len(line) && isLogfmtKeyChar(line[position]) {
+			position++
+		}
+
+		if position == start {
+			position = skipLogfmtGarbage(line, position)
+			continue
+		}
+
+		key := line[start:position]
+
+		if position >= len(line) || line[position] != '=' {
+			if position < len(line) && line[position] == '"' {
+				position = skipLogfmtGarbage(line, position)
+				continue
+			}
+
+			pairs = append(pairs, valuePair{key, ""})
+			continue
+		}
+
+		position++
+
+		var value string
+		if position < len(line) && line[position] == '"' {
+			value, position = parseLogfmtQuotedValue(line, position+1)
+		} else {
+			start = position
+			for position < len(line) && line[position] != ' ' && line[position] != '\t' {
+				position++
+			}
+
+			value = line[start:position]
+		}
+
+		pairs = append(pairs, valuePair{key, value})
+	}
+
+	return pairs
+}
+
+func parseLogfmtQuotedValue(line string, position int) (string, int) {
+	var value strings.Builder
+
+	for position < len(line) {
+		c := line[position]
+
+		switch {
+		case c == '"':
+			return value.String(), position + 1
+		case c == '\\' && position+1 < len(line):
+			switch escaped := line[position+1]; escaped {
+			case 'n':
+				value.WriteByte('\n')
+			case 't':
+				value.WriteByte('\t')
+			case 'r':
+				value.WriteByte('\r')
+			default:
+				value.WriteByte(escaped)
+			}
+
+			position += 2
+		default:
+			value.WriteByte(c)
+			position++
+		}
+	}
+
+	return value.String(), position
+}
+
+func skipLogfmtGarbage(line string, position int) int {
+	for position < len(line) && line[position] != ' ' && line[position] != '\t' {
+		position++
+	}
+
+	return position
+}
+
+func isLogfmtKeyChar(c byte) bool {
+	return c > ' ' && c != '=' && c != '"' && c != 127
+}
@@ -0,0 +1,109 @@
+package herokuLog
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLogfmt(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		expected []valuePair
+	}{
+		{
+			name: "router line",
+			line: `at=info method=GET path="/users?page=2" host=example.com fwd="1.2.3.4" dyno=web.1 connect=0ms service=12ms status=200`,
+			expected: []valuePair{
+				{"at", "info"},
+				{"method", "GET"},
+				{"path", "/users?page=2"},
+				{"host", "example.com"},
+				{"fwd", "1.2.3.4"},
+				{"dyno", "web.1"},
+				{"connect", "0ms"},
+				{"service", "12ms"},
+				{"status", "200"},
+			},
+		},
+		{
+			name: "quoted value with spaces",
+			line: `desc="Request timeout" code=H12`,
+			expected: []valuePair{
+				{"desc", "Request timeout"},
+				{"code", "H12"},
+			},
+		},
+		{
+			name: "escapes",
+			line: `msg="say \"hi\"\n\tand\\leave\r" next=1`,
+			expected: []valuePair{
+				{"msg", "say \"hi\"\n\tand\\leave\r"},
+				{"next", "1"},
+			},
+		},
+		{
+			name: "empty quoted value",
+			line: `a="" b=2`,
+			expected: []valuePair{
+				{"a", ""},
+				{"b", "2"},
+			},
+		},
+		{
+			name: "unterminated quote",
+			line: `a=1 msg="runs to the end b=2`,
+			expected: []valuePair{
+				{"a", "1"},
+				{"msg", "runs to the end b=2"},
+			},
+		},
+		{
+			name: "value containing equals",
+			line: `a=b=c d=e`,
+			expected: []valuePair{
+				{"a", "b=c"},
+				{"d", "e"},
+			},
+		},
+		{
+			name: "empty value",
+			line: `a= b=2`,
+			expected: []valuePair{
+				{"a", ""},
+				{"b", "2"},
+			},
+		},
+		{
+			name: "bare keys",
+			line: "flag\tother a=1",
+			expected: []valuePair{
+				{"flag", ""},
+				{"other", ""},
+				{"a", "1"},
+			},
+		},
+		{
+			name: "garbage is skipped",
+			line: `=x "quoted" key"junk a=1`,
+			expected: []valuePair{
+				{"a", "1"},
+			},
+		},
+		{
+			name:     "empty line",
+			line:     "   ",
+			expected: nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pairs := parseLogfmt(test.line)
+
+			if !reflect.DeepEqual(pairs, test.expected) {
+				t.Errorf("expected %q, got %q", test.expected, pairs)
+			}
+		})
+	}
+}
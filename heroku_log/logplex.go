@@ -0,0 +1,162 @@
+package herokuLog
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// https://devcenter.heroku.com/articles/log-drains#https-drains
+// https://datatracker.ietf.org/doc/html/rfc6587#section-3.4.1
+
+const maxFrameLengthDigits = 9
+
+var ErrInvalidFrame = errors.New("invalid logplex frame")
+
+type LogplexReader struct {
+	reader           *bufio.Reader
+	maxMessageLength int
+	truncated        bool
+}
+
+// NewLogplexReader reads messages from r. Messages longer than
+// maxMessageLength bytes are truncated, 0 disables truncation.
+func NewLogplexReader(r io.Reader, maxMessageLength int) *LogplexReader {
+	return &LogplexReader{bufio.NewReader(r), maxMessageLength, false}
+}
+
+// Truncated reports whether the last message returned by ReadMessage was
+// longer than the maximum message length and got truncated.
+func (r *LogplexReader) Truncated() bool {
+	return r.truncated
+}
+
+// ReadMessage returns the next syslog message from the stream. Messages are
+// expected to be octet counted ("<length> <message>"), but messages starting
+// directly with "<" are read up to the next newline for compatibility with
+// non-transparent framing. io.EOF is returned once the stream is exhausted.
+func (r *LogplexReader) ReadMessage() (string, error) {
+	r.truncated = false
+
+	if err := r.skipSeparators(); err != nil {
+		return "", err
+	}
+
+	first, err := r.reader.Peek(1)
+	if err != nil {
+		return "", err
+	}
+
+	if first[0] == '<' {
+		return r.readNewlineFramed()
+	}
+
+	return r.readOctetCounted()
+}
+
+// ReadLine returns the next non-empty line from the stream regardless of
+// framing, for plain text logs.
+func (r *LogplexReader) ReadLine() (string, error) {
+	r.truncated = false
+
+	if err := r.skipSeparators(); err != nil {
+		return "", err
+	}
+
+	return r.readNewlineFramed()
+}
+
+func (r *LogplexReader) skipSeparators() error {
+	for {
+		b, err := r.reader.ReadByte()
+		if err != nil {
+			return err
+		}
+
+		if b != '\n' && b != '\r' && b != ' ' {
+			return r.reader.UnreadByte()
+		}
+	}
+}
+
+func (r *LogplexReader) readOctetCounted() (string, error) {
+	length := 0
+	digits := 0
+	for {
+		b, err := r.reader.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				return "", fmt.Errorf("%w: unexpected end of stream in frame length", ErrInvalidFrame)
+			}
+
+			return "", err
+		}
+
+		if b == ' ' && digits > 0 {
+			break
+		}
+
+		if b < '0' || b > '9' || digits >= maxFrameLengthDigits {
+			return "", fmt.Errorf("%w: bad frame length", ErrInvalidFrame)
+		}
+
+		length = length*10 + int(b-'0')
+		digits++
+	}
+
+	if length == 0 {
+		return "", fmt.Errorf("%w: zero frame length", ErrInvalidFrame)
+	}
+
+	readLength := length
+	if r.maxMessageLength > 0 && length > r.maxMessageLength {
+		readLength = r.maxMessageLength
+		r.truncated = true
+	}
+
+	// The declared length comes from the client, so the buffer grows only
+	// with bytes actually received instead of being allocated up front.
+	var message bytes.Buffer
+	read, err := message.ReadFrom(io.LimitReader(r.reader, int64(readLength)))
+	if err == nil && readLength < length {
+		var discarded int64
+		discarded, err = io.CopyN(io.Discard, r.reader, int64(length-readLength))
+		read += discarded
+	}
+
+	if err == io.EOF || err == nil && read < int64(length) {
+		return "", fmt.Errorf("%w: frame shorter than declared length %d", ErrInvalidFrame, length)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(message.String(), "\r\n"), nil
+}
+
+func (r *LogplexReader) readNewlineFramed() (string, error) {
+	var line []byte
+	for {
+		fragment, err := r.reader.ReadSlice('\n')
+
+		if r.maxMessageLength > 0 && len(line)+len(fragment) > r.maxMessageLength {
+			fragment = fragment[:r.maxMessageLength-len(line)]
+			r.truncated = true
+		}
+		line = append(line, fragment...)
+
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+
+		return strings.TrimRight(string(line), "\r\n"), nil
+	}
+}
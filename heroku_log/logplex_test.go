@@ -0,0 +1,157 @@
+package herokuLog
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLogplexReaderReadMessage(t *testing.T) {
+	tests := []struct {
+		name             string
+		input            string
+		maxMessageLength int
+		messages         []string
+		truncated        []bool
+		err              error
+	}{
+		{
+			name:     "octet counted frames",
+			input:    "5 <1>1 4 <2>1",
+			messages: []string{"<1>1 ", "<2>1"},
+		},
+		{
+			name:     "trailing newline inside frame",
+			input:    "6 <1>1 a\n7 <1>1 bc\n",
+			messages: []string{"<1>1 a", "<1>1 bc"},
+		},
+		{
+			name:     "newline framed",
+			input:    "<1>1 a\r\n<1>1 b\n\n<1>1 c",
+			messages: []string{"<1>1 a", "<1>1 b", "<1>1 c"},
+		},
+		{
+			name:     "empty stream",
+			input:    "\n\n",
+			messages: nil,
+		},
+		{
+			name:     "short frame",
+			input:    "10 <1>1 a",
+			messages: nil,
+			err:      ErrInvalidFrame,
+		},
+		{
+			name:     "huge declared length",
+			input:    "999999999 <1>1 a",
+			messages: nil,
+			err:      ErrInvalidFrame,
+		},
+		{
+			name:     "too many length digits",
+			input:    "1000000000 <1>1 a",
+			messages: nil,
+			err:      ErrInvalidFrame,
+		},
+		{
+			name:     "missing length",
+			input:    "abc",
+			messages: nil,
+			err:      ErrInvalidFrame,
+		},
+		{
+			name:     "zero length",
+			input:    "0 ",
+			messages: nil,
+			err:      ErrInvalidFrame,
+		},
+		{
+			name:     "unterminated length",
+			input:    "12",
+			messages: nil,
+			err:      ErrInvalidFrame,
+		},
+		{
+			name:             "truncated octet counted frame",
+			input:            "10 <1>1 abcde4 <2>1",
+			maxMessageLength: 6,
+			messages:         []string{"<1>1 a", "<2>1"},
+			truncated:        []bool{true, false},
+		},
+		{
+			name:             "truncated short frame",
+			input:            "10 <1>1 ab",
+			maxMessageLength: 6,
+			messages:         nil,
+			err:              ErrInvalidFrame,
+		},
+		{
+			name:             "truncated newline framed",
+			input:            "<1>1 abcde\n<2>1\n",
+			maxMessageLength: 6,
+			messages:         []string{"<1>1 a", "<2>1"},
+			truncated:        []bool{true, false},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reader := NewLogplexReader(strings.NewReader(test.input), test.maxMessageLength)
+
+			var messages []string
+			var truncated []bool
+			var err error
+			for {
+				var message string
+				message, err = reader.ReadMessage()
+				if err != nil {
+					break
+				}
+
+				messages = append(messages, message)
+				truncated = append(truncated, reader.Truncated())
+			}
+
+			if test.err == nil && err != io.EOF {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			if test.err != nil && !errors.Is(err, test.err) {
+				t.Fatalf("expected error %v, got %v", test.err, err)
+			}
+
+			if !reflect.DeepEqual(messages, test.messages) {
+				t.Errorf("expected messages %q, got %q", test.messages, messages)
+			}
+
+			if test.truncated != nil && !reflect.DeepEqual(truncated, test.truncated) {
+				t.Errorf("expected truncated %v, got %v", test.truncated, truncated)
+			}
+		})
+	}
+}
+
+func TestLogplexReaderReadLine(t *testing.T) {
+	reader := NewLogplexReader(strings.NewReader("12 <1>1 a\n\n2026-10-18T10:00:00+00:00 app[web.1]: b\r\n"), 0)
+
+	var lines []string
+	for {
+		line, err := reader.ReadLine()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			t.Fatalf("unexpected error %v", err)
+		}
+
+		lines = append(lines, line)
+	}
+
+	expected := []string{"12 <1>1 a", "2026-10-18T10:00:00+00:00 app[web.1]: b"}
+	if !reflect.DeepEqual(lines, expected) {
+		t.Errorf("expected lines %q, got %q", expected, lines)
+	}
+}
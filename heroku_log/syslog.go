@@ -0,0 +1,251 @@
+package herokuLog
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	SeverityEmergency = iota
+	SeverityAlert
+	SeverityCritical
+	SeverityError
+	SeverityWarning
+	SeverityNotice
+	SeverityInformational
+	SeverityDebug
+)
+
+const nilValue = "-"
+
+var (
+	ErrInvalidPriority  = errors.New("invalid syslog priority")
+	ErrInvalidVersion   = errors.New("invalid syslog version")
+	ErrInvalidTimestamp = errors.New("invalid syslog timestamp")
+	ErrMissingField     = errors.New("missing syslog header field")
+)
+
+// StructuredData maps SD-IDs to their parameters.
+type StructuredData map[string]map[string]string
+
+type syslogParser struct {
+	line     string
+	position int
+}
+
+func (p *syslogParser) parsePriorityAndVersion() (int, int, error) {
+	if !strings.HasPrefix(p.line, "<") {
+		return 0, 0, ErrInvalidPriority
+	}
+
+	end := strings.IndexByte(p.line, '>')
+	if end < 2 || end > 4 {
+		return 0, 0, ErrInvalidPriority
+	}
+
+	priority, ok := parseDigits(p.line[1:end])
+	if !ok || priority > 191 {
+		return 0, 0, ErrInvalidPriority
+	}
+
+	p.position = end + 1
+	rawVersion := p.nextToken()
+	version, ok := parseDigits(rawVersion)
+	if !ok || version == 0 || len(rawVersion) > 2 {
+		return 0, 0, ErrInvalidVersion
+	}
+
+	return priority, version, nil
+}
+
+func (p *syslogParser) parseTimestamp() (time.Time, error) {
+	rawTimestamp := p.nextToken()
+	if rawTimestamp == "" {
+		return time.Time{}, fmt.Errorf("%w: timestamp", ErrMissingField)
+	}
+
+	if rawTimestamp == nilValue {
+		return time.Time{}, nil
+	}
+
+	timestamp, err := time.Parse(time.RFC3339Nano, rawTimestamp)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%w: %q", ErrInvalidTimestamp, rawTimestamp)
+	}
+
+	return timestamp, nil
+}
+
+func (p *syslogParser) parseHeaderField(name string) (string, error) {
+	value := p.nextToken()
+	if value == "" {
+		return "", fmt.Errorf("%w: %s", ErrMissingField, name)
+	}
+
+	if value == nilValue {
+		return "", nil
+	}
+
+	return value, nil
+}
+
+// Heroku omits STRUCTURED-DATA entirely and starts MSG right after MSGID, so
+// anything that does not look like SD is treated as the start of the message.
+// To keep bracketed application prefixes such as Rails request tags in the
+// message, only SD-IDs that are registered or contain "@" are accepted.
+func (p *syslogParser) parseStructuredData() StructuredData {
+	rest := p.line[p.position:]
+
+	if rest == nilValue || strings.HasPrefix(rest, nilValue+" ") {
+		p.position += len(nilValue)
+		p.skipSpace()
+		return nil
+	}
+
+	if !strings.HasPrefix(rest, "[") {
+		return nil
+	}
+
+	structuredData := StructuredData{}
+	position := p.position
+	for position < len(p.line) && p.line[position] == '[' {
+		id, params, end, ok := parseStructuredDataElement(p.line, position)
+		if !ok {
+			return nil
+		}
+
+		structuredData[id] = params
+		position = end
+	}
+
+	if position < len(p.line) && p.line[position] != ' ' {
+		return nil
+	}
+
+	p.position = position
+	p.skipSpace()
+
+	return structuredData
+}
+
+func (p *syslogParser) parseMessage() string {
+	return strings.TrimPrefix(p.line[p.position:], "\xEF\xBB\xBF")
+}
+
+func (p *syslogParser) nextToken() string {
+	rest := p.line[p.position:]
+
+	end := strings.IndexByte(rest, ' ')
+	if end < 0 {
+		p.position = len(p.line)
+		return rest
+	}
+
+	p.position += end
+	p.skipSpace()
+
+	return rest[:end]
+}
+
+func (p *syslogParser) skipSpace() {
+	if p.position < len(p.line) && p.line[p.position] == ' ' {
+		p.position++
+	}
+}
+
+func parseStructuredDataElement(line string, position int) (string, map[string]string, int, bool) {
+	position++
+
+	idEnd := position
+	for idEnd < len(line) && isStructuredDataNameChar(line[idEnd]) {
+		idEnd++
+	}
+
+	id := line[position:idEnd]
+	if !isKnownStructuredDataID(id) {
+		return "", nil, 0, false
+	}
+
+	params := map[string]string{}
+	position = idEnd
+	for position < len(line) {
+		switch line[position] {
+		case ']':
+			return id, params, position + 1, true
+		case ' ':
+			position++
+		default:
+			nameEnd := position
+			for nameEnd < len(line) && isStructuredDataNameChar(line[nameEnd]) {
+				nameEnd++
+			}
+
+			if nameEnd == position || !strings.HasPrefix(line[nameEnd:], "=\"") {
+				return "", nil, 0, false
+			}
+
+			value, end, ok := parseStructuredDataValue(line, nameEnd+2)
+			if !ok {
+				return "", nil, 0, false
+			}
+
+			params[line[position:nameEnd]] = value
+			position = end
+		}
+	}
+
+	return "", nil, 0, false
+}
+
+func parseStructuredDataValue(line string, position int) (string, int, bool) {
+	var value strings.Builder
+
+	for position < len(line) {
+		c := line[position]
+
+		switch {
+		case c == '"':
+			return value.String(), position + 1, true
+		case c == '\\' && position+1 < len(line) && strings.IndexByte(`"\]`, line[position+1]) >= 0:
+			value.WriteByte(line[position+1])
+			position += 2
+		default:
+			value.WriteByte(c)
+			position++
+		}
+	}
+
+	return "", 0, false
+}
+
+func isStructuredDataNameChar(c byte) bool {
+	return c > ' ' && c < 127 && c != '=' && c != ']' && c != '"'
+}
+
+func isKnownStructuredDataID(id string) bool {
+	switch id {
+	case "timeQuality", "origin", "meta":
+		return true
+	}
+
+	return strings.Contains(id, "@") && !strings.HasPrefix(id, "@")
+}
+
+func parseDigits(value string) (int, bool) {
+	if value == "" {
+		return 0, false
+	}
+
+	number := 0
+	for i := 0; i < len(value); i++ {
+		if value[i] < '0' || value[i] > '9' {
+			return 0, false
+		}
+
+		number = number*10 + int(value[i]-'0')
+	}
+
+	return number, true
+}
@@ -0,0 +1,192 @@
+package herokuLog
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseHerokuLog(t *testing.T) {
+	tests := []struct {
+		name     string
+		line     string
+		expected *HerokuLog
+		err      error
+	}{
+		{
+			name: "router line",
+			line: `<158>1 2026-10-18T10:00:00.123456+00:00 host heroku router - at=info method=GET path="/" host=example.com status=200`,
+			expected: &HerokuLog{
+				Priority:  158,
+				Facility:  19,
+				Severity:  SeverityInformational,
+				Version:   1,
+				Timestamp: time.Date(2026, 10, 18, 10, 0, 0, 123456000, time.UTC),
+				Hostname:  "host",
+				Source:    "heroku",
+				Dyno:      "router",
+				Line:      `at=info method=GET path="/" host=example.com status=200`,
+			},
+		},
+		{
+			name: "app line with nil values",
+			line: `<190>1 2026-10-18T10:00:00+00:00 - app web.1 - - Started GET "/"`,
+			expected: &HerokuLog{
+				Priority:  190,
+				Facility:  23,
+				Severity:  SeverityInformational,
+				Version:   1,
+				Timestamp: time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC),
+				Source:    "app",
+				Dyno:      "web.1",
+				Line:      `Started GET "/"`,
+			},
+		},
+		{
+			name: "nil timestamp",
+			line: `<13>1 - host app web.1 - message`,
+			expected: &HerokuLog{
+				Priority: 13,
+				Facility: 1,
+				Severity: SeverityNotice,
+				Version:  1,
+				Hostname: "host",
+				Source:   "app",
+				Dyno:     "web.1",
+				Line:     "message",
+			},
+		},
+		{
+			name: "structured data",
+			line: `<13>1 2026-10-18T10:00:00Z host app web.1 ID47 [exampleSDID@32473 iut="3" eventSource="App\"lication"][origin ip="10.0.0.1"] message`,
+			expected: &HerokuLog{
+				Priority:  13,
+				Facility:  1,
+				Severity:  SeverityNotice,
+				Version:   1,
+				Timestamp: time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC),
+				Hostname:  "host",
+				Source:    "app",
+				Dyno:      "web.1",
+				MsgID:     "ID47",
+				StructuredData: StructuredData{
+					"exampleSDID@32473": {"iut": "3", "eventSource": `App"lication`},
+					"origin":            {"ip": "10.0.0.1"},
+				},
+				Line: "message",
+			},
+		},
+		{
+			name: "bracketed message text",
+			line: `<13>1 2026-10-18T10:00:00Z host app web.1 - [abc-123] [ip=1.2.3.4] Started GET "/"`,
+			expected: &HerokuLog{
+				Priority:  13,
+				Facility:  1,
+				Severity:  SeverityNotice,
+				Version:   1,
+				Timestamp: time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC),
+				Hostname:  "host",
+				Source:    "app",
+				Dyno:      "web.1",
+				Line:      `[abc-123] [ip=1.2.3.4] Started GET "/"`,
+			},
+		},
+		{
+			name: "unterminated structured data",
+			line: `<13>1 2026-10-18T10:00:00Z host app web.1 - [origin ip="10.0.0.1" message`,
+			expected: &HerokuLog{
+				Priority:  13,
+				Facility:  1,
+				Severity:  SeverityNotice,
+				Version:   1,
+				Timestamp: time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC),
+				Hostname:  "host",
+				Source:    "app",
+				Dyno:      "web.1",
+				Line:      `[origin ip="10.0.0.1" message`,
+			},
+		},
+		{
+			name: "byte order mark",
+			line: "<13>1 2026-10-18T10:00:00Z host app web.1 - - \xEF\xBB\xBFmessage",
+			expected: &HerokuLog{
+				Priority:  13,
+				Facility:  1,
+				Severity:  SeverityNotice,
+				Version:   1,
+				Timestamp: time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC),
+				Hostname:  "host",
+				Source:    "app",
+				Dyno:      "web.1",
+				Line:      "message",
+			},
+		},
+		{
+			name: "empty message",
+			line: `<13>1 2026-10-18T10:00:00Z host app web.1 -`,
+			expected: &HerokuLog{
+				Priority:  13,
+				Facility:  1,
+				Severity:  SeverityNotice,
+				Version:   1,
+				Timestamp: time.Date(2026, 10, 18, 10, 0, 0, 0, time.UTC),
+				Hostname:  "host",
+				Source:    "app",
+				Dyno:      "web.1",
+			},
+		},
+		{
+			name: "missing priority",
+			line: `1 2026-10-18T10:00:00Z host app web.1 - message`,
+			err:  ErrInvalidPriority,
+		},
+		{
+			name: "priority out of range",
+			line: `<192>1 2026-10-18T10:00:00Z host app web.1 - message`,
+			err:  ErrInvalidPriority,
+		},
+		{
+			name: "invalid version",
+			line: `<13>0 2026-10-18T10:00:00Z host app web.1 - message`,
+			err:  ErrInvalidVersion,
+		},
+		{
+			name: "invalid timestamp",
+			line: `<13>1 yesterday host app web.1 - message`,
+			err:  ErrInvalidTimestamp,
+		},
+		{
+			name: "missing msgid",
+			line: `<13>1 2026-10-18T10:00:00Z host app web.1`,
+			err:  ErrMissingField,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hLog, err := ParseHerokuLog("", test.line)
+
+			if test.err != nil {
+				if !errors.Is(err, test.err) {
+					t.Fatalf("expected error %v, got %v", test.err, err)
+				}
+
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			if !hLog.Timestamp.Equal(test.expected.Timestamp) {
+				t.Errorf("expected timestamp %v, got %v", test.expected.Timestamp, hLog.Timestamp)
+			}
+
+			hLog.Timestamp = test.expected.Timestamp
+			if !reflect.DeepEqual(hLog, test.expected) {
+				t.Errorf("expected %+v, got %+v", test.expected, hLog)
+			}
+		})
+	}
+}
@@ -24,12 +24,15 @@ func ParseMillis(value string) float64 {
 }
 
 func ParseSize(value string) float64 {
-	multiplier := 1
+	multiplier := 1.0
 	raw_value := value
 
-	if strings.HasSuffix(value, "GB") {
+	if strings.HasSuffix(value, "TB") {
+		multiplier = 1024 * 1024 * 1024 * 1024
+		raw_value = strings.Replace(value, "TB", "", 1)
+	} else if strings.HasSuffix(value, "GB") {
 		multiplier = 1024 * 1024 * 1024
-		raw_value = strings.Replace(value, "MB", "", 1)
+		raw_value = strings.Replace(value, "GB", "", 1)
 	} else if strings.HasSuffix(value, "MB") {
 		multiplier = 1024 * 1024
 		raw_value = strings.Replace(value, "MB", "", 1)
@@ -42,6 +45,16 @@ func ParseSize(value string) float64 {
 	}
 
 	raw_number_value, _ := strconv.ParseFloat(raw_value, 64)
-	bytes := raw_number_value * float64(multiplier)
+	bytes := raw_number_value * multiplier
 	return bytes
 }
+
+// ParsePercentage parses values such as "45.2%" as ratio between 0.0 and 1.0,
+// values without % suffix are expected to be ratios already.
+func ParsePercentage(value string) float64 {
+	if strings.HasSuffix(value, "%") {
+		return ParseNumberWithSuffix(value, "%") / 100.0
+	}
+
+	return ParseSimpleNumber(value)
+}
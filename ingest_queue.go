@@ -0,0 +1,166 @@
+package main
+
+import (
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	herokuLog "heroku-logs-exporter/heroku_log"
+	"heroku-logs-exporter/metrics"
+)
+
+var (
+	ingestQueueWaitDuration = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "heroku_logs_exporter_ingest_queue_wait_seconds",
+			Help:    "Time batches of log lines spent in the ingest queue before a worker picked them up.",
+			Buckets: []float64{.0001, .0005, .001, .005, .01, .05, .1, .5, 1, 5, 10},
+		},
+	)
+	ingestDroppedBatchesCount = metrics.NewCounterVec(
+		"heroku_logs_exporter_ingest_dropped_batches_total",
+		"Batches of log lines rejected because the ingest queue was full.",
+		[]string{"app_name"},
+	)
+	ingestDroppedLinesCount = metrics.NewCounterVec(
+		"heroku_logs_exporter_ingest_dropped_lines_total",
+		"Log lines rejected because the ingest queue was full.",
+		[]string{"app_name"},
+	)
+)
+
+type ingestBatch struct {
+	logs     []*herokuLog.HerokuLog
+	enqueued time.Time
+}
+
+type ingestWorker struct {
+	ready   *sync.Cond
+	batches []ingestBatch
+}
+
+// ingestQueue decouples receiving logs from updating metrics. Batches of an
+// app are always processed by the same worker, in the order they were
+// queued, so that older values of gauges do not overwrite newer ones. The
+// queue holds up to size log lines, a larger batch is accepted only by an
+// empty queue.
+type ingestQueue struct {
+	mutex   sync.Mutex
+	notFull *sync.Cond
+	size    int
+	length  int
+	workers []*ingestWorker
+}
+
+func newIngestQueue(size int, workers int) *ingestQueue {
+	q := &ingestQueue{size: size}
+	q.notFull = sync.NewCond(&q.mutex)
+	for i := 0; i < workers; i++ {
+		q.workers = append(q.workers, &ingestWorker{ready: sync.NewCond(&q.mutex)})
+	}
+
+	promauto.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "heroku_logs_exporter_ingest_queue_length",
+			Help: "Number of log lines waiting in the ingest queue.",
+		},
+		func() float64 {
+			q.mutex.Lock()
+			defer q.mutex.Unlock()
+
+			return float64(q.length)
+		},
+	)
+	promauto.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "heroku_logs_exporter_ingest_queue_capacity",
+			Help: "Maximum number of log lines in the ingest queue.",
+		},
+		func() float64 { return float64(q.size) },
+	)
+
+	return q
+}
+
+func (q *ingestQueue) Start() {
+	for _, worker := range q.workers {
+		go q.work(worker)
+	}
+}
+
+func (q *ingestQueue) work(worker *ingestWorker) {
+	for {
+		q.mutex.Lock()
+		for len(worker.batches) == 0 {
+			worker.ready.Wait()
+		}
+
+		batch := worker.batches[0]
+		worker.batches[0] = ingestBatch{}
+		worker.batches = worker.batches[1:]
+		q.length -= len(batch.logs)
+		q.notFull.Broadcast()
+		q.mutex.Unlock()
+
+		ingestQueueWaitDuration.Observe(time.Since(batch.enqueued).Seconds())
+
+		for _, hLog := range batch.logs {
+			updateMetrics(hLog)
+		}
+	}
+}
+
+// push must be called with the mutex held.
+func (q *ingestQueue) push(appName string, logs []*herokuLog.HerokuLog) {
+	hash := fnv.New32a()
+	hash.Write([]byte(appName))
+
+	worker := q.workers[hash.Sum32()%uint32(len(q.workers))]
+	worker.batches = append(worker.batches, ingestBatch{logs, time.Now()})
+	worker.ready.Signal()
+
+	q.length += len(logs)
+}
+
+// full must be called with the mutex held.
+func (q *ingestQueue) full(lines int) bool {
+	return q.length > 0 && q.length+lines > q.size
+}
+
+// TryEnqueue adds logs to the queue unless it is full.
+func (q *ingestQueue) TryEnqueue(appName string, logs []*herokuLog.HerokuLog) bool {
+	if len(logs) == 0 {
+		return true
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	if q.full(len(logs)) {
+		ingestDroppedBatchesCount.WithLabelValues(appName).Inc()
+		ingestDroppedLinesCount.WithLabelValues(appName).Add(float64(len(logs)))
+		return false
+	}
+
+	q.push(appName, logs)
+	return true
+}
+
+// Enqueue adds logs to the queue, waiting for free space when it is full.
+func (q *ingestQueue) Enqueue(appName string, logs []*herokuLog.HerokuLog) {
+	if len(logs) == 0 {
+		return
+	}
+
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	for q.full(len(logs)) {
+		q.notFull.Wait()
+	}
+
+	q.push(appName, logs)
+}
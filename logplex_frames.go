@@ -0,0 +1,139 @@
+package main
+
+import (
+	"container/list"
+	"strconv"
+	"sync"
+	"time"
+
+	"heroku-logs-exporter/metrics"
+)
+
+var (
+	duplicateFramesCount = metrics.NewCounterVec(
+		"heroku_logs_exporter_duplicate_frames_total",
+		"Logplex frames skipped because their Logplex-Frame-Id was already processed.",
+		[]string{"app_name"},
+	)
+	msgCountMismatchCount = metrics.NewCounterVec(
+		"heroku_logs_exporter_msg_count_mismatch_frames_total",
+		"Logplex frames where the number of read messages differs from Logplex-Msg-Count. Kind is missing when fewer messages were read and extra when more were read.",
+		[]string{"app_name", "kind"},
+	)
+	msgCountMismatchMessages = metrics.NewCounterVec(
+		"heroku_logs_exporter_msg_count_mismatch_messages_total",
+		"Absolute difference between read messages and Logplex-Msg-Count summed over mismatched frames.",
+		[]string{"app_name", "kind"},
+	)
+)
+
+type frameKey struct {
+	drainToken string
+	frameID    string
+}
+
+type seenFrame struct {
+	key     frameKey
+	expires time.Time
+}
+
+// frameDeduplicator remembers recently processed Logplex frames so that frames
+// retried by Logplex after a timeout are not counted twice.
+type frameDeduplicator struct {
+	mutex   sync.Mutex
+	ttl     time.Duration
+	maxSize int
+	frames  map[frameKey]*list.Element
+	order   *list.List
+}
+
+func newFrameDeduplicator(ttl time.Duration, maxSize int) *frameDeduplicator {
+	return &frameDeduplicator{
+		ttl:     ttl,
+		maxSize: maxSize,
+		frames:  make(map[frameKey]*list.Element),
+		order:   list.New(),
+	}
+}
+
+func (d *frameDeduplicator) enabled() bool {
+	return d.maxSize > 0 && d.ttl > 0
+}
+
+// Claim reports whether the frame was not seen before and marks it as seen in
+// the same step, so a retry arriving while the frame is still being processed
+// is skipped as well. Frames without a Logplex-Frame-Id are always claimed.
+func (d *frameDeduplicator) Claim(drainToken string, frameID string) bool {
+	if !d.enabled() || frameID == "" {
+		return true
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	now := time.Now()
+	d.expire(now)
+
+	key := frameKey{drainToken, frameID}
+	if _, ok := d.frames[key]; ok {
+		return false
+	}
+
+	d.frames[key] = d.order.PushBack(&seenFrame{key, now.Add(d.ttl)})
+
+	for d.order.Len() > d.maxSize {
+		d.remove(d.order.Front())
+	}
+
+	return true
+}
+
+// Release forgets a claimed frame that could not be processed, so that its
+// retry is accepted.
+func (d *frameDeduplicator) Release(drainToken string, frameID string) {
+	if !d.enabled() || frameID == "" {
+		return
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	if element, ok := d.frames[frameKey{drainToken, frameID}]; ok {
+		d.remove(element)
+	}
+}
+
+func (d *frameDeduplicator) expire(now time.Time) {
+	for element := d.order.Front(); element != nil; element = d.order.Front() {
+		if element.Value.(*seenFrame).expires.After(now) {
+			return
+		}
+
+		d.remove(element)
+	}
+}
+
+func (d *frameDeduplicator) remove(element *list.Element) {
+	delete(d.frames, element.Value.(*seenFrame).key)
+	d.order.Remove(element)
+}
+
+func checkMsgCount(appName string, rawMsgCount string, messages int) {
+	if rawMsgCount == "" {
+		return
+	}
+
+	msgCount, err := strconv.Atoi(rawMsgCount)
+	if err != nil {
+		return
+	}
+
+	switch {
+	case messages < msgCount:
+		msgCountMismatchCount.WithLabelValues(appName, "missing").Inc()
+		msgCountMismatchMessages.WithLabelValues(appName, "missing").Add(float64(msgCount - messages))
+	case messages > msgCount:
+		msgCountMismatchCount.WithLabelValues(appName, "extra").Inc()
+		msgCountMismatchMessages.WithLabelValues(appName, "extra").Add(float64(messages - msgCount))
+	}
+}
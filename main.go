@@ -1,12 +1,17 @@
 package main
 
 import (
-	"bufio"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	herokuLog "heroku-logs-exporter/heroku_log"
@@ -14,67 +19,438 @@ import (
 )
 
 var (
-	listenAddress       = flag.String("web.listen-address", ":9841", "Address to listen on for telemetry")
+	listenAddress       = flag.String("web.listen-address", ":9841", "Address to listen on for Heroku Log Drains, and for telemetry unless -web.telemetry-listen-address is set")
+	telemetryAddress    = flag.String("web.telemetry-listen-address", "", "Separate address to listen on for telemetry, served on -web.listen-address when empty")
+	telemetryTokenFile  = flag.String("web.telemetry-bearer-token-file", "", "File with bearer token required to access telemetry")
+	telemetryUsersFile  = flag.String("web.telemetry-basic-auth-file", "", "htpasswd file with bcrypt hashed passwords of users allowed to access telemetry")
+	tlsCertFile         = flag.String("web.tls-cert-file", "", "Certificate file to serve HTTPS with, reloaded when it changes")
+	tlsKeyFile          = flag.String("web.tls-key-file", "", "Private key file to serve HTTPS with, reloaded when it changes")
 	metricsPath         = flag.String("web.telemetry-path", "/metrics", "Path under which to expose metrics")
 	logsPath            = flag.String("web.logs-path", "/logs", "Path under which to accept Heroku Log Drain")
 	logsTokenParamName  = flag.String("web.logs-token-param-name", "token", "Parameter name to check against token parameter value in Heroku Log Drain requests")
 	logsTokenParamValue = flag.String("web.logs-token-param-value", "", "Token to check against token parameter in Heroku Log Drain requests")
+	logsBasicAuthFile   = flag.String("web.logs-basic-auth-file", "", "htpasswd file with bcrypt hashed passwords required as basic auth credentials in Heroku Log Drain requests")
+	configReloadPeriod  = flag.Duration("web.config-reload-interval", 30*time.Second, "How often to check drains file, auth files and TLS certificates for changes, 0 disables reloading")
+	drainsFilePath      = flag.String("web.drains-file", "", "JSON file mapping Logplex-Drain-Token values and per-app secrets to app names, overrides -web.logs-token-param-value")
+	frameDedupTTL       = flag.Duration("logplex.frame-dedup-ttl", 10*time.Minute, "How long to remember processed Logplex-Frame-Id values to skip frames retried by Logplex")
+	frameDedupSize      = flag.Int("logplex.frame-dedup-size", 100000, "Maximum number of remembered Logplex-Frame-Id values, 0 disables deduplication")
+	maxRequestBodySize  = flag.Int64("web.max-request-body-size", 16*1024*1024, "Maximum size in bytes of a drain request body as received, 0 disables the limit")
+	maxMessageLength    = flag.Int("logplex.max-message-length", 64*1024, "Maximum length in bytes of a single syslog message, longer messages are truncated, 0 disables the limit")
+	readHeaderTimeout   = flag.Duration("web.read-header-timeout", 10*time.Second, "Maximum duration for reading request headers")
+	readTimeout         = flag.Duration("web.read-timeout", time.Minute, "Maximum duration for reading an entire request including the body")
+	writeTimeout        = flag.Duration("web.write-timeout", time.Minute, "Maximum duration before timing out writes of a response")
+	idleTimeout         = flag.Duration("web.idle-timeout", 2*time.Minute, "Maximum duration to wait for the next request on a keep-alive connection")
+	maxDecompressedSize = flag.Int64("web.max-decompressed-body-size", 64*1024*1024, "Maximum size in bytes of a gzip or deflate compressed drain request body after decompression, 0 disables the limit")
+	rejectedLinesPath   = flag.String("web.rejected-lines-path", "", "Path under which to expose recently rejected log lines as JSON, e.g. /debug/rejected-lines, empty disables it")
+	rejectedLinesSize   = flag.Int("web.rejected-lines-size", 100, "Number of recently rejected log lines to keep")
+	ingestQueueSize     = flag.Int("ingest.queue-size", 100000, "Maximum number of received log lines waiting to be processed, drains get 503 responses when the queue is full")
+	ingestWorkers       = flag.Int("ingest.workers", 4, "Number of workers updating metrics from queued log lines")
+	rateLimitLines      = flag.Float64("ingest.rate-limit-lines", 0, "Maximum log lines per second accepted for each app or drain token, 0 disables the limit")
+	rateLimitBytes      = flag.Float64("ingest.rate-limit-bytes", 0, "Maximum bytes of log lines per second accepted for each app or drain token, 0 disables the limit")
+	rateLimitBurst      = flag.Duration("ingest.rate-limit-burst", 10*time.Second, "How many seconds worth of the rate limit can be used at once after a quiet period")
+	rateLimitKeyName    = flag.String("ingest.rate-limit-key", rateLimitKeyAppName, "Whether rate limits apply per app_name or per drain_token registered in -web.drains-file")
+	rateLimitAction     = flag.String("ingest.rate-limit-action", rateLimitActionReject, "What to do with log lines over the rate limit: reject drops them, sample processes every -ingest.rate-limit-sample-ratio-th of them")
+	rateLimitSample     = flag.Int("ingest.rate-limit-sample-ratio", 10, "Process one of this many log lines over the rate limit when -ingest.rate-limit-action is sample")
+	syslogListenAddress = flag.String("syslog.listen-address", "", "Address to listen on for syslog:// and syslog+tls:// Heroku Log Drains, disabled when empty")
+	syslogTLSCertFile   = flag.String("syslog.tls-cert-file", "", "Certificate file for syslog+tls:// drains, plain TCP is used when empty")
+	syslogTLSKeyFile    = flag.String("syslog.tls-key-file", "", "Private key file for syslog+tls:// drains")
+	syslogDrainApps     = flag.String("syslog.drain-apps", "", "Comma separated drain-token=app-name pairs used to resolve the app name of syslog connections")
+	syslogDefaultApp    = flag.String("syslog.default-app-name", "", "App name for syslog connections with unknown drain token, such connections are closed when empty")
+	syslogIdleTimeout   = flag.Duration("syslog.idle-timeout", 5*time.Minute, "Close syslog connections idle for longer than this")
+	routesFilePath      = flag.String("router.routes-file", "", "JSON file with rules mapping request paths to the route label of Heroku Router histograms, reloaded when it changes")
+	maxRoutes           = flag.Int("router.max-routes", 100, "Maximum number of distinct routes per app for paths not matching any rule, others are reported as route other")
+	topPathsSize        = flag.Int("router.top-paths", 10, "Number of raw paths per app with the most requests, service duration and errors to expose, 0 disables tracking")
+	topPathsPath        = flag.String("web.top-paths-path", "", "Path under which to expose top raw paths of each app as JSON, e.g. /debug/top-paths, empty disables it")
+	slowRequestsSize    = flag.Int("router.slow-requests", 10, "Number of slowest router requests per app to keep, 0 disables tracking")
+	slowRequestsWindow  = flag.Duration("router.slow-requests-window", 15*time.Minute, "Sliding window over which the slowest router requests are kept")
+	slowRequestsPath    = flag.String("web.slow-requests-path", "", "Path under which to expose the slowest router requests of each app as JSON, e.g. /debug/slow-requests, empty disables it")
+	joinTTL             = flag.Duration("router.join-ttl", time.Minute, "How long router and application log lines wait to be joined by request_id, 0 disables joining")
+	joinMaxPending      = flag.Int("router.join-max-pending", 100000, "Maximum number of log lines waiting to be joined by request_id")
 )
 
 var (
+	routes       = metrics.NewRouteNormalizer(0)
+	topPaths     = metrics.NewTopPaths()
+	slowRequests = metrics.NewSlowRequests()
+	joiner       = metrics.NewRequestJoiner()
+
 	exportedMetrics = []metrics.HerokuMetricGroup{
 		metrics.NewHerokuSystemMetrics(),
 		metrics.NewHerokuRuntimeMetrics(),
 		metrics.NewHerokuPostgresMetrics(),
 		metrics.NewHerokuPgbouncerMetrics(),
-		metrics.NewHerokuRouterMetrics(),
+		metrics.NewHerokuRedisMetrics(),
+		metrics.NewHerokuKafkaMetrics(),
+		metrics.NewHerokuRouterMetrics(routes, topPaths, slowRequests, joiner),
 		metrics.NewRackTimeoutMetrics(),
+		joiner,
 	}
+
+	drains        *drainRegistry
+	drainUsers    *htpasswdFile
+	frameDedup    *frameDeduplicator
+	logsQueue     *ingestQueue
+	logsLimiter   *rateLimiter
+	rejectedLines *rejectedLinesRing
 )
 
 func helloHandler(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintf(w, "heroku-logs-exporter")
 }
 
+func updateMetrics(hLog *herokuLog.HerokuLog) {
+	for _, metric := range exportedMetrics {
+		updateMetricGroup(metric, hLog)
+	}
+}
+
 func logsHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method != "POST" {
 		http.Error(w, "Bad Request", http.StatusBadRequest)
 		return
 	}
 
-	if *logsTokenParamName != "" && *logsTokenParamValue != "" {
-		if *logsTokenParamValue != r.URL.Query().Get(*logsTokenParamName) {
-			log.Printf("Token mismatch: %s\n", r.URL)
-			http.Error(w, "Bad Request", http.StatusBadRequest)
+	appName, reason, ok := authenticateDrain(r)
+	if !ok {
+		user, _, _ := r.BasicAuth()
+		log.Printf("Drain authentication failed (%s) for user %q: %s\n", reason, user, redactURL(r.URL, *logsTokenParamName))
+		drainAuthFailuresCount.WithLabelValues(reason).Inc()
+
+		if reason == "missing_basic_auth" || reason == "invalid_basic_auth" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="heroku-logs-exporter"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
+
+		http.Error(w, "Forbidden", http.StatusForbidden)
+		return
 	}
 
-	appName := r.URL.Query().Get("app_name")
+	drainToken := r.Header.Get("Logplex-Drain-Token")
+	frameID := r.Header.Get("Logplex-Frame-Id")
+
+	if !frameDedup.Claim(drainToken, frameID) {
+		log.Printf("Skipping duplicate frame %s from %s\n", frameID, appName)
+		duplicateFramesCount.WithLabelValues(appName).Inc()
+		return
+	}
+
+	var requestBody io.Reader = r.Body
+	if *maxRequestBodySize > 0 {
+		requestBody = &maxSizeReader{r.Body, *maxRequestBodySize, errRequestBodyTooLarge}
+	}
+
+	wireBody := &countingReader{reader: requestBody}
+	body, encoding, err := decodeDrainBody(wireBody, r.Header.Get("Content-Encoding"), *maxDecompressedSize)
+	if err != nil {
+		log.Printf("Failed to decode logs from %s: %v\n", appName, err)
+		frameDedup.Release(drainToken, frameID)
+
+		status := readErrorStatus(appName, err)
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	uncompressedBody := &countingReader{reader: body}
+	defer func() {
+		receivedBytesCount.WithLabelValues(appName, encoding).Add(float64(wireBody.count))
+		receivedUncompressedBytesCount.WithLabelValues(appName).Add(float64(uncompressedBody.count))
+	}()
+
+	var logs []*herokuLog.HerokuLog
+	var limitSpent rateLimitSpent
+	limitKey := rateLimitKey(appName, drainToken)
+	messages := 0
+	status := http.StatusOK
+	reader := herokuLog.NewLogplexReader(uncompressedBody, *maxMessageLength)
+	for {
+		line, err := reader.ReadMessage()
+		if err == io.EOF {
+			break
+		}
+
+		if errors.Is(err, herokuLog.ErrInvalidFrame) {
+			log.Printf("Framing error after %d log lines from %s: %v\n", len(logs), appName, err)
+			rejectLine(appName, "", err)
+			status = http.StatusBadRequest
+			break
+		}
+
+		if errors.Is(err, errRequestBodyTooLarge) {
+			log.Printf("Request body from %s exceeds %d bytes after %d log lines\n", appName, *maxRequestBodySize, len(logs))
+			limitViolationsCount.WithLabelValues(appName, "body_size").Inc()
+			status = http.StatusRequestEntityTooLarge
+			break
+		}
+
+		if errors.Is(err, errDecompressedBodyTooLarge) {
+			log.Printf("Decompressed body from %s exceeds %d bytes after %d log lines\n", appName, *maxDecompressedSize, len(logs))
+			limitViolationsCount.WithLabelValues(appName, "decompressed_body_size").Inc()
+			status = http.StatusRequestEntityTooLarge
+			break
+		}
+
+		if errors.Is(err, errCorruptBody) {
+			log.Printf("Failed to decode logs from %s after %d log lines: %v\n", appName, len(logs), err)
+			status = http.StatusBadRequest
+			break
+		}
+
+		if err != nil {
+			log.Printf("Failed to read logs from %s after %d log lines: %v\n", appName, len(logs), err)
+			frameDedup.Release(drainToken, frameID)
 
-	count := 0
-	scanner := bufio.NewScanner(r.Body)
-	for scanner.Scan() {
-		hLog := herokuLog.ParseHerokuLog(appName, scanner.Text())
+			status := readErrorStatus(appName, err)
+			http.Error(w, http.StatusText(status), status)
+			return
+		}
+
+		messages = messages + 1
+
+		if reader.Truncated() {
+			limitViolationsCount.WithLabelValues(appName, "message_length").Inc()
+		}
+
+		hLog, err := herokuLog.ParseHerokuLog(appName, line)
+		if err != nil {
+			log.Printf("Failed to parse log line from %s: %v\n", appName, err)
+			rejectLine(appName, line, err)
+			continue
+		}
 
-		for _, metric := range exportedMetrics {
-			metric.UpdateFromLog(hLog)
+		if !logsLimiter.Allow(limitKey, appName, len(line), &limitSpent) {
+			continue
 		}
 
-		count = count + 1
+		logs = append(logs, hLog)
 	}
 
-	log.Printf("Processed %d log lines from %s\n", count, appName)
+	// A rejected request is not processed even partially, so that the lines
+	// read before the error are not counted again when it is retried.
+	if status != http.StatusOK {
+		log.Printf("Rejecting %d log lines from %s\n", len(logs), appName)
+		frameDedup.Release(drainToken, frameID)
+		logsLimiter.Refund(limitKey, limitSpent)
+		http.Error(w, http.StatusText(status), status)
+		return
+	}
+
+	if !logsQueue.TryEnqueue(appName, logs) {
+		log.Printf("Ingest queue is full, rejecting %d log lines from %s\n", len(logs), appName)
+		frameDedup.Release(drainToken, frameID)
+		logsLimiter.Refund(limitKey, limitSpent)
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	checkMsgCount(appName, r.Header.Get("Logplex-Msg-Count"), messages)
+
+	log.Printf("Queued %d log lines from %s\n", len(logs), appName)
+}
+
+// readErrorStatus returns the response status of a drain request whose body
+// could not be read or decoded.
+func readErrorStatus(appName string, err error) int {
+	switch {
+	case errors.Is(err, errUnsupportedContentEncoding):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, errCorruptBody):
+		return http.StatusBadRequest
+	case errors.Is(err, errRequestBodyTooLarge):
+		limitViolationsCount.WithLabelValues(appName, "body_size").Inc()
+		return http.StatusRequestEntityTooLarge
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		limitViolationsCount.WithLabelValues(appName, "read_timeout").Inc()
+	}
+
+	return http.StatusInternalServerError
+}
+
+func newHTTPServer(address string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              address,
+		Handler:           handler,
+		ReadHeaderTimeout: *readHeaderTimeout,
+		ReadTimeout:       *readTimeout,
+		WriteTimeout:      *writeTimeout,
+		IdleTimeout:       *idleTimeout,
+	}
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "replay" {
+		if err := runReplay(os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+
+		return
+	}
+
 	flag.Parse()
 
-	http.HandleFunc("/", helloHandler)
-	http.HandleFunc(*logsPath, logsHandler)
-	http.Handle(*metricsPath, promhttp.Handler())
+	if *drainsFilePath != "" {
+		drains = newDrainRegistry()
+		if err := drains.Load(*drainsFilePath); err != nil {
+			log.Fatal(err)
+		}
+
+		prometheus.MustRegister(drains)
+		watchFile(*drainsFilePath, *configReloadPeriod, drains.Load)
+	}
+
+	if *logsBasicAuthFile != "" {
+		drainUsers = newHtpasswdFile()
+		if err := drainUsers.Load(*logsBasicAuthFile); err != nil {
+			log.Fatal(err)
+		}
+
+		watchFile(*logsBasicAuthFile, *configReloadPeriod, drainUsers.Load)
+	}
+
+	topPaths.SetSize(*topPathsSize)
+	joiner.Configure(*joinTTL, *joinMaxPending, false)
+
+	if *slowRequestsWindow < time.Second {
+		log.Fatal("-router.slow-requests-window must be at least 1s")
+	}
+
+	slowRequests.Configure(*slowRequestsSize, *slowRequestsWindow)
+	routes.SetMaxRoutes(*maxRoutes)
+	if *routesFilePath != "" {
+		if err := routes.Load(*routesFilePath); err != nil {
+			log.Fatal(err)
+		}
+
+		watchFile(*routesFilePath, *configReloadPeriod, routes.Load)
+	}
+
+	frameDedup = newFrameDeduplicator(*frameDedupTTL, *frameDedupSize)
+	rejectedLines = newRejectedLinesRing(*rejectedLinesSize)
+
+	if *ingestWorkers < 1 {
+		log.Fatal("-ingest.workers must be at least 1")
+	}
+
+	if *rateLimitKeyName != rateLimitKeyAppName && *rateLimitKeyName != rateLimitKeyDrainToken {
+		log.Fatalf("-ingest.rate-limit-key must be %s or %s\n", rateLimitKeyAppName, rateLimitKeyDrainToken)
+	}
+
+	var err error
+	logsLimiter, err = newRateLimiter(*rateLimitLines, *rateLimitBytes, *rateLimitBurst, *rateLimitAction, *rateLimitSample)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	logsQueue = newIngestQueue(*ingestQueueSize, *ingestWorkers)
+	logsQueue.Start()
+
+	if *syslogListenAddress != "" {
+		drainApps, err := parseDrainApps(*syslogDrainApps)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		listener, err := listenSyslog(*syslogListenAddress, *syslogTLSCertFile, *syslogTLSKeyFile, *configReloadPeriod)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		server := &syslogServer{listener, drainApps, *syslogDefaultApp, *syslogIdleTimeout, *maxMessageLength}
+
+		log.Printf("Listening for syslog drains on %s\n", *syslogListenAddress)
+
+		go func() {
+			log.Fatal(server.Serve())
+		}()
+	}
+
+	var telemetryToken *bearerToken
+	if *telemetryTokenFile != "" {
+		telemetryToken = &bearerToken{}
+		if err := telemetryToken.Load(*telemetryTokenFile); err != nil {
+			log.Fatal(err)
+		}
+
+		watchFile(*telemetryTokenFile, *configReloadPeriod, telemetryToken.Load)
+	}
+
+	var telemetryUsers *htpasswdFile
+	if *telemetryUsersFile != "" {
+		telemetryUsers = newHtpasswdFile()
+		if err := telemetryUsers.Load(*telemetryUsersFile); err != nil {
+			log.Fatal(err)
+		}
+
+		watchFile(*telemetryUsersFile, *configReloadPeriod, telemetryUsers.Load)
+	}
+
+	logsMux := http.NewServeMux()
+	logsMux.HandleFunc("/", helloHandler)
+	logsMux.HandleFunc(*logsPath, logsHandler)
+
+	telemetryMux := logsMux
+	if *telemetryAddress != "" {
+		telemetryMux = http.NewServeMux()
+		telemetryMux.HandleFunc("/", helloHandler)
+	}
+
+	// OpenMetrics is negotiated for scrapers that accept it, so exemplars are
+	// exposed.
+	metricsHandler := promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}),
+	)
+	telemetryMux.Handle(*metricsPath, telemetryAuthHandler(telemetryToken, telemetryUsers, metricsHandler))
+
+	if *rejectedLinesPath != "" {
+		telemetryMux.Handle(*rejectedLinesPath, telemetryAuthHandler(telemetryToken, telemetryUsers, rejectedLines))
+	}
+
+	if *topPathsPath != "" {
+		topPathsHandler := &appsJSONHandler{"top paths", func(appName string) (interface{}, bool) {
+			top := topPaths.Top(appName)
+			return top, appName == "" || len(top) > 0
+		}}
+		telemetryMux.Handle(*topPathsPath, telemetryAuthHandler(telemetryToken, telemetryUsers, topPathsHandler))
+	}
+
+	if *slowRequestsPath != "" {
+		slowRequestsHandler := &appsJSONHandler{"slow requests", func(appName string) (interface{}, bool) {
+			slowest := slowRequests.Slowest(appName)
+			return slowest, appName == "" || len(slowest) > 0
+		}}
+		telemetryMux.Handle(*slowRequestsPath, telemetryAuthHandler(telemetryToken, telemetryUsers, slowRequestsHandler))
+	}
+
+	if *telemetryAddress != "" {
+		telemetryServer := newHTTPServer(*telemetryAddress, telemetryMux)
+
+		log.Printf("Serving telemetry on %s\n", *telemetryAddress)
+
+		go func() {
+			log.Fatal(telemetryServer.ListenAndServe())
+		}()
+	}
+
+	server := newHTTPServer(*listenAddress, logsMux)
+
+	if *tlsCertFile != "" || *tlsKeyFile != "" {
+		certificate, err := newCertificateReloader("web", *tlsCertFile, *tlsKeyFile, *configReloadPeriod)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		server.TLSConfig = certificate.TLSConfig()
+
+		log.Printf("Starting heroku-logs-exporter on %s with TLS\n", *listenAddress)
+		log.Fatal(server.ListenAndServeTLS("", ""))
+	}
 
 	log.Printf("Starting heroku-logs-exporter on %s\n", *listenAddress)
 
-	log.Fatal(http.ListenAndServe(*listenAddress, nil))
+	log.Fatal(server.ListenAndServe())
 }
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func gzipBody(t *testing.T, content string) []byte {
+	var body bytes.Buffer
+
+	writer := gzip.NewWriter(&body)
+	if _, err := writer.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return body.Bytes()
+}
+
+func TestLogsHandlerRejectsWholeRequest(t *testing.T) {
+	frameDedup = newFrameDeduplicator(time.Minute, 10)
+	logsQueue = newIngestQueue(100, 1)
+	rejectedLines = newRejectedLinesRing(10)
+
+	line := "<13>1 2026-10-18T10:00:00Z host app web.1 - message"
+	frame := fmt.Sprintf("%d %s", len(line), line)
+
+	valid := gzipBody(t, frame+frame)
+
+	// The CRC-32 is the second to last 4 bytes of a gzip stream.
+	badChecksum := append([]byte{}, valid...)
+	badChecksum[len(badChecksum)-8] ^= 0xff
+
+	tests := []struct {
+		name     string
+		encoding string
+		body     []byte
+		status   int
+		queued   int
+	}{
+		{"framing error", "", []byte(frame + "12 <13>1"), http.StatusBadRequest, 0},
+		{"corrupt gzip trailer", "gzip", badChecksum, http.StatusBadRequest, 0},
+		{"corrupt gzip header", "gzip", []byte("not gzip"), http.StatusBadRequest, 0},
+		{"unsupported encoding", "br", valid, http.StatusUnsupportedMediaType, 0},
+		{"retried frame", "gzip", valid, http.StatusOK, 2},
+		{"duplicate frame", "gzip", valid, http.StatusOK, 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("POST", "/logs?app_name=app", bytes.NewReader(test.body))
+			r.Header.Set("Content-Encoding", test.encoding)
+			r.Header.Set("Logplex-Frame-Id", "frame-1")
+
+			w := httptest.NewRecorder()
+			logsHandler(w, r)
+
+			if w.Code != test.status {
+				t.Errorf("expected status %d, got %d", test.status, w.Code)
+			}
+
+			logsQueue.mutex.Lock()
+			queued := logsQueue.length
+			logsQueue.mutex.Unlock()
+
+			if queued != test.queued {
+				t.Errorf("expected %d queued lines, got %d", test.queued, queued)
+			}
+		})
+	}
+}
@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	herokuLog "heroku-logs-exporter/heroku_log"
+)
+
+// Keys under which applications commonly log the trace id of a request.
+var traceIDKeys = []string{"trace_id", "traceId", "dd.trace_id"}
+
+// exemplarFromLog returns exemplar labels with the request id found under
+// requestIDKey and the trace id of the line, if any.
+func exemplarFromLog(hLog *herokuLog.HerokuLog, requestIDKey string) prometheus.Labels {
+	requestID, _ := hLog.Value(requestIDKey)
+
+	return newExemplar(requestID, traceIDFromLog(hLog))
+}
+
+func traceIDFromLog(hLog *herokuLog.HerokuLog) string {
+	for _, key := range traceIDKeys {
+		if value, ok := hLog.Value(key); ok {
+			return value
+		}
+	}
+
+	return ""
+}
+
+// newExemplar returns nil when there is nothing to attach. Exemplar labels
+// are limited to prometheus.ExemplarMaxRunes runes in total, so the trace id is
+// left out when it does not fit together with the request id.
+func newExemplar(requestID string, traceID string) prometheus.Labels {
+	exemplar := prometheus.Labels{}
+	runes := 0
+
+	for _, label := range [][2]string{{"request_id", requestID}, {"trace_id", traceID}} {
+		if label[1] == "" || !utf8.ValidString(label[1]) {
+			continue
+		}
+
+		labelRunes := utf8.RuneCountInString(label[0]) + utf8.RuneCountInString(label[1])
+		if runes+labelRunes > prometheus.ExemplarMaxRunes {
+			continue
+		}
+
+		exemplar[label[0]] = label[1]
+		runes += labelRunes
+	}
+
+	if len(exemplar) == 0 {
+		return nil
+	}
+
+	return exemplar
+}
+
+// observeWithExemplar observes value with exemplar when there is one and the
+// observer supports exemplars.
+func observeWithExemplar(observer prometheus.Observer, value float64, exemplar prometheus.Labels) {
+	if exemplarObserver, ok := observer.(prometheus.ExemplarObserver); ok && exemplar != nil {
+		exemplarObserver.ObserveWithExemplar(value, exemplar)
+		return
+	}
+
+	observer.Observe(value)
+}
@@ -14,3 +14,24 @@ func NewGaugeVec(name string, help string, labels []string) *prometheus.GaugeVec
 		labels,
 	)
 }
+
+func NewCounterVec(name string, help string, labels []string) *prometheus.CounterVec {
+	return promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: name,
+			Help: help,
+		},
+		labels,
+	)
+}
+
+func NewHistogramVec(name string, help string, labels []string, buckets []float64) *prometheus.HistogramVec {
+	return promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    name,
+			Help:    help,
+			Buckets: buckets,
+		},
+		labels,
+	)
+}
@@ -0,0 +1,135 @@
+package metrics
+
+import (
+	"strings"
+
+	herokuLog "heroku-logs-exporter/heroku_log"
+)
+
+// https://devcenter.heroku.com/articles/kafka-on-heroku
+
+type HerokuKafkaMetrics struct {
+	Metrics []HerokuMetric
+}
+
+func NewHerokuKafkaMetrics() *HerokuKafkaMetrics {
+	labels := []string{"app_name", "addon", "broker"}
+
+	return &HerokuKafkaMetrics{
+		[]HerokuMetric{
+			NewHerokuGaugeMetric(
+				"sample#bytes-in-per-second",
+				"heroku_kafka_metrics_bytes_in_per_second",
+				"Bytes per second produced to the broker.",
+				labels,
+				herokuLog.ParseSize,
+			),
+			NewHerokuGaugeMetric(
+				"sample#bytes-out-per-second",
+				"heroku_kafka_metrics_bytes_out_per_second",
+				"Bytes per second consumed from the broker.",
+				labels,
+				herokuLog.ParseSize,
+			),
+			NewHerokuGaugeMetric(
+				"sample#messages-in-per-second",
+				"heroku_kafka_metrics_messages_in_per_second",
+				"Messages per second produced to the broker.",
+				labels,
+				nil,
+			),
+			NewHerokuGaugeMetric(
+				"sample#partitions",
+				"heroku_kafka_metrics_partition_count",
+				"The number of partitions hosted by the broker.",
+				labels,
+				nil,
+			),
+			NewHerokuGaugeMetric(
+				"sample#under-replicated-partitions",
+				"heroku_kafka_metrics_under_replicated_partition_count",
+				"The number of partitions hosted by the broker which are not fully replicated.",
+				labels,
+				nil,
+			),
+			NewHerokuGaugeMetric(
+				"sample#volume-bytes-used",
+				"heroku_kafka_metrics_volume_used_bytes",
+				"Amount of disk space used by the broker.",
+				labels,
+				herokuLog.ParseSize,
+			),
+			NewHerokuGaugeMetric(
+				"sample#volume-bytes-total",
+				"heroku_kafka_metrics_volume_total_bytes",
+				"Total amount of disk space available to the broker.",
+				labels,
+				herokuLog.ParseSize,
+			),
+			NewHerokuGaugeMetric(
+				"sample#volume-used-percentage",
+				"heroku_kafka_metrics_volume_used_ratio",
+				"Ratio of the broker disk space that has been used, between 0.0 and 1.0.",
+				labels,
+				herokuLog.ParsePercentage,
+			),
+			NewHerokuGaugeMetric(
+				"sample#cpu-percentage",
+				"heroku_kafka_metrics_cpu_ratio",
+				"Ratio of CPU time used by the broker, between 0.0 and 1.0.",
+				labels,
+				herokuLog.ParsePercentage,
+			),
+			NewHerokuGaugeMetric(
+				"sample#load-avg-1m",
+				"heroku_kafka_metrics_load_avg_1m",
+				"The average system load of the broker over a period of 1 minute divided by the number of available CPUs.",
+				labels,
+				nil,
+			),
+			NewHerokuGaugeMetric(
+				"sample#load-avg-5m",
+				"heroku_kafka_metrics_load_avg_5m",
+				"The average system load of the broker over a period of 5 minutes divided by the number of available CPUs.",
+				labels,
+				nil,
+			),
+			NewHerokuGaugeMetric(
+				"sample#load-avg-15m",
+				"heroku_kafka_metrics_load_avg_15m",
+				"The average system load of the broker over a period of 15 minutes divided by the number of available CPUs.",
+				labels,
+				nil,
+			),
+			NewHerokuGaugeMetric(
+				"sample#memory-total",
+				"heroku_kafka_metrics_memory_total_bytes",
+				"Total amount of broker memory available.",
+				labels,
+				herokuLog.ParseSize,
+			),
+			NewHerokuGaugeMetric(
+				"sample#memory-free",
+				"heroku_kafka_metrics_memory_free_bytes",
+				"Amount of free broker memory available.",
+				labels,
+				herokuLog.ParseSize,
+			),
+		},
+	}
+}
+
+func (m *HerokuKafkaMetrics) UpdateFromLog(log *herokuLog.HerokuLog) {
+	if log.Source != "app" || (log.Dyno != "heroku-kafka" && !strings.HasPrefix(log.Dyno, "heroku-kafka.")) {
+		return
+	}
+
+	// Broker index is reported as broker, older logs only identify it by source.
+	broker, ok := log.Value("broker")
+	if !ok {
+		broker = log.ValueOrUnknown("source")
+	}
+
+	labels := []string{log.AppName, log.ValueOrUnknown("addon"), broker}
+	updateMetricsFromLog(m.Metrics, labels, log)
+}
@@ -13,6 +13,13 @@ type HerokuMetric interface {
 	Delete(labels []string)
 }
 
+// HerokuExemplarMetric is implemented by metrics that can attach exemplars,
+// such as the request id, to observed values.
+type HerokuExemplarMetric interface {
+	HerokuMetric
+	UpdateWithExemplar(value string, labels []string, exemplar prometheus.Labels)
+}
+
 type HerokuMetricGroup interface {
 	UpdateFromLog(log *herokuLog.HerokuLog)
 }
@@ -25,6 +32,21 @@ func updateMetricsFromLog(metrics []HerokuMetric, labels []string, hLog *herokuL
 	}
 }
 
+func updateMetricsFromLogWithExemplar(metrics []HerokuMetric, labels []string, hLog *herokuLog.HerokuLog, exemplar prometheus.Labels) {
+	for _, metric := range metrics {
+		value, ok := hLog.Value(metric.HerokuName())
+		if !ok {
+			continue
+		}
+
+		if exemplarMetric, ok := metric.(HerokuExemplarMetric); ok {
+			exemplarMetric.UpdateWithExemplar(value, labels, exemplar)
+		} else {
+			metric.Update(value, labels)
+		}
+	}
+}
+
 func deleteMetrics(metrics []HerokuMetric, labels []string) {
 	for _, metric := range metrics {
 		metric.Delete(labels)
@@ -70,6 +92,48 @@ func (m HerokuCounterMetric) Delete(labels []string) {
 	m.metric.DeleteLabelValues(labels...)
 }
 
+// HerokuValueCounterMetric adds the parsed value to the counter instead of
+// counting occurrences, e.g. for bytes sent.
+type HerokuValueCounterMetric struct {
+	herokuName string
+	metric     *prometheus.CounterVec
+	parser     func(value string) float64
+}
+
+func NewHerokuValueCounterMetric(herokuName string, prometheusName string, help string, labels []string, parser func(value string) float64) *HerokuValueCounterMetric {
+	m := new(HerokuValueCounterMetric)
+	m.herokuName = herokuName
+	m.metric = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: prometheusName,
+			Help: help,
+		},
+		labels,
+	)
+
+	if parser == nil {
+		m.parser = herokuLog.ParseSimpleNumber
+	} else {
+		m.parser = parser
+	}
+
+	return m
+}
+
+func (m HerokuValueCounterMetric) HerokuName() string {
+	return m.herokuName
+}
+
+func (m HerokuValueCounterMetric) Update(value string, labels []string) {
+	if number := m.parser(value); number > 0 {
+		m.metric.WithLabelValues(labels...).Add(number)
+	}
+}
+
+func (m HerokuValueCounterMetric) Delete(labels []string) {
+	m.metric.DeleteLabelValues(labels...)
+}
+
 type HerokuGaugeMetric struct {
 	herokuName string
 	metric     *prometheus.GaugeVec
@@ -186,6 +250,10 @@ func (m HerokuHistogramMetric) Update(value string, labels []string) {
 	m.metric.WithLabelValues(labels...).Observe(m.parser(value))
 }
 
+func (m HerokuHistogramMetric) UpdateWithExemplar(value string, labels []string, exemplar prometheus.Labels) {
+	observeWithExemplar(m.metric.WithLabelValues(labels...), m.parser(value), exemplar)
+}
+
 func (m HerokuHistogramMetric) Delete(labels []string) {
 	m.metric.DeleteLabelValues(labels...)
 }
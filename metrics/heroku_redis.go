@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	herokuLog "heroku-logs-exporter/heroku_log"
+)
+
+// https://devcenter.heroku.com/articles/heroku-redis-metrics-logs
+
+type HerokuRedisMetrics struct {
+	Metrics []HerokuMetric
+}
+
+func NewHerokuRedisMetrics() *HerokuRedisMetrics {
+	labels := []string{"app_name", "source", "addon"}
+
+	return &HerokuRedisMetrics{
+		[]HerokuMetric{
+			NewHerokuGaugeMetric(
+				"sample#active-connections",
+				"heroku_redis_metrics_active_connection_count",
+				"The number of connections established on the Redis instance.",
+				labels,
+				nil,
+			),
+			NewHerokuGaugeMetric(
+				"sample#load-avg-1m",
+				"heroku_redis_metrics_load_avg_1m",
+				"The average system load over a period of 1 minute divided by the number of available CPUs. A load-avg of 1.0 indicates that, on average, processes were requesting CPU resources for 100% of the timespan. This number includes I/O wait.",
+				labels,
+				nil,
+			),
+			NewHerokuGaugeMetric(
+				"sample#load-avg-5m",
+				"heroku_redis_metrics_load_avg_5m",
+				"The average system load over a period of 5 minutes divided by the number of available CPUs. A load-avg of 1.0 indicates that, on average, processes were requesting CPU resources for 100% of the timespan. This number includes I/O wait.",
+				labels,
+				nil,
+			),
+			NewHerokuGaugeMetric(
+				"sample#load-avg-15m",
+				"heroku_redis_metrics_load_avg_15m",
+				"The average system load over a period of 15 minutes divided by the number of available CPUs. A load-avg of 1.0 indicates that, on average, processes were requesting CPU resources for 100% of the timespan. This number includes I/O wait.",
+				labels,
+				nil,
+			),
+			NewHerokuGaugeMetric(
+				"sample#read-iops",
+				"heroku_redis_metrics_read_iops",
+				"Number of read operations in I/O sizes of 16KB blocks.",
+				labels,
+				nil,
+			),
+			NewHerokuGaugeMetric(
+				"sample#write-iops",
+				"heroku_redis_metrics_write_iops",
+				"Number of write operations in I/O sizes of 16KB blocks.",
+				labels,
+				nil,
+			),
+			NewHerokuGaugeMetric(
+				"sample#memory-total",
+				"heroku_redis_metrics_memory_total_bytes",
+				"Total amount of server memory available.",
+				labels,
+				herokuLog.ParseSize,
+			),
+			NewHerokuGaugeMetric(
+				"sample#memory-free",
+				"heroku_redis_metrics_memory_free_bytes",
+				"Amount of free memory available.",
+				labels,
+				herokuLog.ParseSize,
+			),
+			NewHerokuGaugeMetric(
+				"sample#memory-cached",
+				"heroku_redis_metrics_memory_cached_bytes",
+				"Amount of memory being used by the OS for page cache.",
+				labels,
+				herokuLog.ParseSize,
+			),
+			NewHerokuGaugeMetric(
+				"sample#memory-redis",
+				"heroku_redis_metrics_memory_redis_bytes",
+				"Amount of memory used by Redis, including the data set and internal overhead.",
+				labels,
+				herokuLog.ParseSize,
+			),
+			NewHerokuGaugeMetric(
+				"sample#hit-rate",
+				"heroku_redis_metrics_hit_rate",
+				"Ratio of key lookups that found the requested key, between 0.0 and 1.0.",
+				labels,
+				nil,
+			),
+			NewHerokuGaugeMetric(
+				"sample#evicted-keys",
+				"heroku_redis_metrics_evicted_key_count",
+				"The number of keys evicted due to the maxmemory limit since the last sample.",
+				labels,
+				nil,
+			),
+		},
+	}
+}
+
+func (m *HerokuRedisMetrics) UpdateFromLog(log *herokuLog.HerokuLog) {
+	if log.Source != "app" || log.Dyno != "heroku-redis" {
+		return
+	}
+
+	labels := []string{log.AppName, log.ValueOrUnknown("source"), log.ValueOrUnknown("addon")}
+	updateMetricsFromLog(m.Metrics, labels, log)
+}
@@ -4,12 +4,27 @@ import (
 	herokuLog "heroku-logs-exporter/heroku_log"
 )
 
+// https://devcenter.heroku.com/articles/http-routing#heroku-router-log-format
+// https://devcenter.heroku.com/articles/error-codes
+
 type HerokuRouterMetrics struct {
-	Metrics []HerokuMetric
+	Metrics      []HerokuMetric
+	RouteMetrics []HerokuMetric
+	ErrorMetrics []HerokuMetric
+
+	routes       *RouteNormalizer
+	topPaths     *TopPaths
+	slowRequests *SlowRequests
+	joiner       *RequestJoiner
 }
 
-func NewHerokuRouterMetrics() *HerokuRouterMetrics {
+// Histograms are additionally labeled by route, see RouteNormalizer. Raw paths
+// are tracked by topPaths and the slowest requests by slowRequests. Served
+// requests are passed to joiner to be paired with application logs.
+func NewHerokuRouterMetrics(routes *RouteNormalizer, topPaths *TopPaths, slowRequests *SlowRequests, joiner *RequestJoiner) *HerokuRouterMetrics {
 	labels := []string{"app_name", "dyno", "host", "method", "protocol", "status"}
+	routeLabels := append(append([]string{}, labels...), "route")
+	errorLabels := []string{"app_name", "code", "desc", "sock"}
 
 	return &HerokuRouterMetrics{
 		[]HerokuMetric{
@@ -27,12 +42,20 @@ func NewHerokuRouterMetrics() *HerokuRouterMetrics {
 				labels,
 				herokuLog.ParseMillis,
 			),
-
+			NewHerokuValueCounterMetric(
+				"bytes",
+				"heroku_router_response_bytes_total",
+				"Bytes sent in responses reported by Heroku Router.",
+				labels,
+				nil,
+			),
+		},
+		[]HerokuMetric{
 			NewHerokuHistogramMetric(
 				"service",
 				"heroku_router_service_duration_histogram_seconds",
 				"Request service duration reported by Heroku Router as histogram.",
-				labels,
+				routeLabels,
 				nil,
 				herokuLog.ParseMillis,
 			),
@@ -40,11 +63,32 @@ func NewHerokuRouterMetrics() *HerokuRouterMetrics {
 				"connect",
 				"heroku_router_connect_duration_histogram_seconds",
 				"Request connect duration reported by Heroku Router as histogram.",
-				labels,
+				routeLabels,
 				[]float64{.001, .002, .003, .004, .005, .01, 0.025, .05, .1, .25, .5, 1.0, 2.5, 5.0, 10.0, 20.0},
 				herokuLog.ParseMillis,
 			),
+
+			NewHerokuHistogramMetric(
+				"bytes",
+				"heroku_router_response_size_bytes",
+				"Response size reported by Heroku Router as histogram.",
+				routeLabels,
+				[]float64{256, 1024, 4096, 16384, 65536, 262144, 1048576, 4194304, 16777216, 67108864},
+				nil,
+			),
+		},
+		[]HerokuMetric{
+			NewHerokuCounterMetric(
+				"code",
+				"heroku_router_errors_total",
+				"Errors reported by Heroku Router, such as H12 Request timeout.",
+				errorLabels,
+			),
 		},
+		routes,
+		topPaths,
+		slowRequests,
+		joiner,
 	}
 }
 
@@ -53,6 +97,21 @@ func (m *HerokuRouterMetrics) UpdateFromLog(hLog *herokuLog.HerokuLog) {
 		return
 	}
 
+	m.topPaths.Observe(hLog)
+	m.slowRequests.Observe(hLog)
+
+	if _, ok := hLog.Value("code"); ok {
+		errorLabels := []string{hLog.AppName, hLog.ValueOrUnknown("code"), hLog.ValueOrUnknown("desc"), hLog.ValueOrUnknown("sock")}
+		updateMetricsFromLog(m.ErrorMetrics, errorLabels, hLog)
+		return
+	}
+
 	labels := []string{hLog.AppName, hLog.ValueOrUnknown("dyno"), hLog.ValueOrUnknown("host"), hLog.ValueOrUnknown("method"), hLog.ValueOrUnknown("protocol"), hLog.ValueOrUnknown("status")}
 	updateMetricsFromLog(m.Metrics, labels, hLog)
+
+	route := m.routes.Route(hLog.AppName, hLog.ValueOrUnknown("path"))
+	routeLabels := append(labels, route)
+	updateMetricsFromLogWithExemplar(m.RouteMetrics, routeLabels, hLog, exemplarFromLog(hLog, "request_id"))
+
+	m.joiner.ObserveRouter(hLog, route)
 }
@@ -29,11 +29,24 @@ func (m *HerokuSystemMetrics) UpdateFromLog(hLog *herokuLog.HerokuLog) {
 		return
 	}
 
+	if hLog.Dyno == "router" {
+		if _, ok := hLog.Value("code"); ok {
+			labels := []string{hLog.AppName, hLog.Dyno, hLog.ValueOrUnknown("code")}
+			updateMetricFromLog(m.Metrics, "error", labels, "")
+		}
+
+		return
+	}
+
 	if !strings.HasPrefix(hLog.Line, "Error ") {
 		return
 	}
 
 	parts := strings.SplitN(hLog.Line, " ", 3)
+	if len(parts) < 2 || parts[1] == "" {
+		return
+	}
+
 	errorCode := parts[1]
 
 	labels := []string{hLog.AppName, hLog.Dyno, errorCode}
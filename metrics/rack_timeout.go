@@ -59,10 +59,14 @@ func (m *RackTimeoutMetrics) UpdateFromLog(log *herokuLog.HerokuLog) {
 		return
 	}
 
-	if !strings.Contains(log.Line, "source=rack-timeout") || !strings.Contains(log.Line, "state=completed") {
+	if source, _ := log.Value("source"); source != "rack-timeout" {
+		return
+	}
+
+	if state, _ := log.Value("state"); state != "completed" {
 		return
 	}
 
 	labels := []string{log.AppName, log.Dyno}
-	updateMetricsFromLog(m.Metrics, labels, log)
+	updateMetricsFromLogWithExemplar(m.Metrics, labels, log, exemplarFromLog(log, "id"))
 }
@@ -0,0 +1,266 @@
+package metrics
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	herokuLog "heroku-logs-exporter/heroku_log"
+)
+
+var (
+	requestBreakdownBuckets = []float64{.005, .01, .02, 0.04, .06, .08, 0.1, .125, 0.15, 0.175, 0.2, 0.3, 0.4, .5, 1, 2.5, 5, 10, 15, 20}
+
+	requestRouterOverheadHistogram = NewHistogramVec(
+		"heroku_request_router_overhead_seconds",
+		"Router connect and service duration not spent waiting or in the application, of requests joined with application logs by request_id.",
+		[]string{"app_name", "route"},
+		requestBreakdownBuckets,
+	)
+	requestQueueHistogram = NewHistogramVec(
+		"heroku_request_queue_seconds",
+		"Time requests waited before the application started processing them, reported by rack-timeout as wait, of requests joined with router logs by request_id.",
+		[]string{"app_name", "route"},
+		requestBreakdownBuckets,
+	)
+	requestApplicationHistogram = NewHistogramVec(
+		"heroku_request_application_seconds",
+		"Time the application spent processing requests, of requests joined with router logs by request_id.",
+		[]string{"app_name", "route"},
+		requestBreakdownBuckets,
+	)
+
+	requestJoinsCount = NewCounterVec(
+		"heroku_logs_exporter_request_joins_total",
+		"Router log lines joined with application log lines by request_id.",
+		[]string{"app_name"},
+	)
+	requestJoinExpiredCount = NewCounterVec(
+		"heroku_logs_exporter_request_join_expired_total",
+		"Router or application log lines dropped from the join buffer without a match, by the side that was waiting.",
+		[]string{"app_name", "side"},
+	)
+)
+
+type pendingRequest struct {
+	added time.Time
+
+	hasRouter bool
+	route     string
+	connect   float64
+	service   float64
+
+	hasApp      bool
+	wait        float64
+	hasWait     bool
+	application float64
+	traceID     string
+}
+
+type pendingRequestKey struct {
+	appName   string
+	requestID string
+}
+
+type pendingRequestRef struct {
+	key   pendingRequestKey
+	added time.Time
+}
+
+// RequestJoiner pairs Heroku Router lines with application lines of the same
+// request_id, and splits the router service duration into in-dyno queueing,
+// application time and the rest, the router overhead. Whichever line comes
+// first waits in the buffer for at most ttl, a ttl of 0 turns joining off. The
+// wait is measured by the wall clock, or by timestamps of the lines when logs
+// are replayed.
+//
+// Application lines are recognized from rack-timeout (id, wait, service),
+// lograge (request_id, duration) and JSON logs (request_id, duration_ms).
+type RequestJoiner struct {
+	mutex      sync.Mutex
+	ttl        time.Duration
+	maxPending int
+	logTime    bool
+	latest     time.Time
+	pending    map[pendingRequestKey]*pendingRequest
+	queue      []pendingRequestRef
+}
+
+func NewRequestJoiner() *RequestJoiner {
+	return &RequestJoiner{pending: make(map[pendingRequestKey]*pendingRequest)}
+}
+
+// Configure sets how long and how many lines wait to be joined. With logTime
+// lines expire by the latest timestamp of joined lines instead of the current
+// time.
+func (j *RequestJoiner) Configure(ttl time.Duration, maxPending int, logTime bool) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	j.ttl = ttl
+	j.maxPending = maxPending
+	j.logTime = logTime
+	j.latest = time.Time{}
+	j.pending = make(map[pendingRequestKey]*pendingRequest)
+	j.queue = nil
+}
+
+// ObserveRouter must be called only with Heroku Router lines of served
+// requests, route is the normalized route of the request.
+func (j *RequestJoiner) ObserveRouter(hLog *herokuLog.HerokuLog, route string) {
+	requestID, ok := hLog.Value("request_id")
+	if !ok || requestID == "" {
+		return
+	}
+
+	j.update(hLog, requestID, func(request *pendingRequest) {
+		request.hasRouter = true
+		request.route = route
+		request.connect = herokuLog.ParseMillis(hLog.ValueOrUnknown("connect"))
+		request.service = herokuLog.ParseMillis(hLog.ValueOrUnknown("service"))
+	})
+}
+
+func (j *RequestJoiner) UpdateFromLog(hLog *herokuLog.HerokuLog) {
+	if hLog.Source != "app" {
+		return
+	}
+
+	if source, _ := hLog.Value("source"); source == "rack-timeout" {
+		if state, _ := hLog.Value("state"); state != "completed" {
+			return
+		}
+
+		requestID, ok := hLog.Value("id")
+		if !ok || requestID == "" {
+			return
+		}
+
+		j.update(hLog, requestID, func(request *pendingRequest) {
+			request.hasApp = true
+			request.application = herokuLog.ParseMillis(hLog.ValueOrUnknown("service"))
+			request.wait = herokuLog.ParseMillis(hLog.ValueOrUnknown("wait"))
+			request.hasWait = true
+			request.traceID = traceIDFromLog(hLog)
+		})
+
+		return
+	}
+
+	requestID, ok := hLog.Value("request_id")
+	if !ok || requestID == "" {
+		return
+	}
+
+	duration, ok := hLog.Value("duration_ms")
+	if !ok {
+		duration, ok = hLog.Value("duration")
+	}
+
+	if !ok {
+		return
+	}
+
+	j.update(hLog, requestID, func(request *pendingRequest) {
+		if request.hasApp {
+			return
+		}
+
+		request.hasApp = true
+		request.application = herokuLog.ParseSimpleNumber(strings.TrimSuffix(duration, "ms")) / 1000.0
+		request.traceID = traceIDFromLog(hLog)
+	})
+}
+
+func (j *RequestJoiner) update(hLog *herokuLog.HerokuLog, requestID string, apply func(request *pendingRequest)) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+
+	if j.ttl <= 0 {
+		return
+	}
+
+	now := j.now(hLog)
+	j.expire(now)
+
+	appName := hLog.AppName
+	key := pendingRequestKey{appName, requestID}
+	request, ok := j.pending[key]
+	if !ok {
+		request = &pendingRequest{added: now}
+		j.pending[key] = request
+		j.queue = append(j.queue, pendingRequestRef{key, now})
+	}
+
+	apply(request)
+
+	if request.hasRouter && request.hasApp {
+		delete(j.pending, key)
+		observeRequestBreakdown(appName, requestID, request)
+	}
+}
+
+// now returns the current time, or the latest log time seen so that lines
+// slightly out of order or without a timestamp do not move the clock back.
+func (j *RequestJoiner) now(hLog *herokuLog.HerokuLog) time.Time {
+	if !j.logTime {
+		return time.Now()
+	}
+
+	if hLog.Timestamp.After(j.latest) {
+		j.latest = hLog.Timestamp
+	}
+
+	return j.latest
+}
+
+// expire drops requests waiting longer than ttl, and the oldest ones while
+// there are more than maxPending of them. Joined requests are removed from
+// the queue lazily.
+func (j *RequestJoiner) expire(now time.Time) {
+	head := 0
+	for ; head < len(j.queue); head++ {
+		ref := j.queue[head]
+
+		request, ok := j.pending[ref.key]
+		if !ok || !request.added.Equal(ref.added) {
+			continue
+		}
+
+		if now.Sub(ref.added) < j.ttl && len(j.pending) <= j.maxPending {
+			break
+		}
+
+		side := "application"
+		if request.hasRouter {
+			side = "router"
+		}
+
+		requestJoinExpiredCount.WithLabelValues(ref.key.appName, side).Inc()
+		delete(j.pending, ref.key)
+	}
+
+	j.queue = j.queue[head:]
+	if cap(j.queue) > 2*len(j.queue)+1024 {
+		j.queue = append([]pendingRequestRef{}, j.queue...)
+	}
+}
+
+func observeRequestBreakdown(appName string, requestID string, request *pendingRequest) {
+	requestJoinsCount.WithLabelValues(appName).Inc()
+
+	exemplar := newExemplar(requestID, request.traceID)
+
+	overhead := request.connect + request.service - request.application
+	if request.hasWait {
+		overhead -= request.wait
+		observeWithExemplar(requestQueueHistogram.WithLabelValues(appName, request.route), request.wait, exemplar)
+	}
+
+	if overhead < 0 {
+		overhead = 0
+	}
+
+	observeWithExemplar(requestApplicationHistogram.WithLabelValues(appName, request.route), request.application, exemplar)
+	observeWithExemplar(requestRouterOverheadHistogram.WithLabelValues(appName, request.route), overhead, exemplar)
+}
@@ -0,0 +1,240 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+const (
+	RouteOther = "other"
+
+	routeIDSegment   = ":id"
+	routeUUIDSegment = ":uuid"
+)
+
+var otherRoutesCount = NewCounterVec(
+	"heroku_logs_exporter_router_other_routes_total",
+	"Router requests reported with route other because the limit of distinct routes of the app was reached.",
+	[]string{"app_name"},
+)
+
+// RouteRule maps request paths to a route. Template is a path such as
+// /users/:id where segments starting with ":" match any single segment and a
+// trailing "*" matches the rest of the path. Regex is matched against the path
+// and reported as Route.
+type RouteRule struct {
+	Template string `json:"template"`
+	Regex    string `json:"regex"`
+	Route    string `json:"route"`
+
+	pattern *regexp.Regexp
+}
+
+type routesFile struct {
+	Routes []RouteRule `json:"routes"`
+}
+
+// RouteNormalizer turns request paths into route label values of bounded
+// cardinality. Paths matching a rule are reported as its route. Other paths
+// have their numeric and UUID segments collapsed, and are kept only until the
+// app has maxRoutes distinct collapsed routes; the rest is reported as other.
+type RouteNormalizer struct {
+	mutex     sync.RWMutex
+	rules     []RouteRule
+	maxRoutes int
+
+	seenMutex sync.Mutex
+	seen      map[string]map[string]bool
+}
+
+func NewRouteNormalizer(maxRoutes int) *RouteNormalizer {
+	return &RouteNormalizer{
+		maxRoutes: maxRoutes,
+		seen:      make(map[string]map[string]bool),
+	}
+}
+
+func (n *RouteNormalizer) SetMaxRoutes(maxRoutes int) {
+	n.seenMutex.Lock()
+	defer n.seenMutex.Unlock()
+
+	n.maxRoutes = maxRoutes
+}
+
+func (n *RouteNormalizer) Load(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var file routesFile
+	if err := json.Unmarshal(content, &file); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	for i := range file.Routes {
+		rule := &file.Routes[i]
+
+		switch {
+		case rule.Template != "" && rule.Regex == "":
+			if !strings.HasPrefix(rule.Template, "/") {
+				return fmt.Errorf("%s: route %d: template %q must start with /", path, i, rule.Template)
+			}
+
+			rule.pattern = regexp.MustCompile(templatePattern(rule.Template))
+			if rule.Route == "" {
+				rule.Route = rule.Template
+			}
+		case rule.Regex != "" && rule.Template == "":
+			if rule.Route == "" {
+				return fmt.Errorf("%s: route %d: regex %q needs route", path, i, rule.Regex)
+			}
+
+			rule.pattern, err = regexp.Compile(rule.Regex)
+			if err != nil {
+				return fmt.Errorf("%s: route %d: %w", path, i, err)
+			}
+		default:
+			return fmt.Errorf("%s: route %d needs either template or regex", path, i)
+		}
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	n.rules = file.Routes
+
+	return nil
+}
+
+// Route returns the route of a request path, which may include a query string.
+func (n *RouteNormalizer) Route(appName string, path string) string {
+	path = stripQuery(path)
+	if !strings.HasPrefix(path, "/") {
+		return RouteOther
+	}
+
+	n.mutex.RLock()
+	for _, rule := range n.rules {
+		if rule.pattern.MatchString(path) {
+			n.mutex.RUnlock()
+			return rule.Route
+		}
+	}
+	n.mutex.RUnlock()
+
+	route := collapsePath(path)
+
+	n.seenMutex.Lock()
+	defer n.seenMutex.Unlock()
+
+	routes, ok := n.seen[appName]
+	if !ok {
+		routes = make(map[string]bool)
+		n.seen[appName] = routes
+	}
+
+	if routes[route] {
+		return route
+	}
+
+	if len(routes) >= n.maxRoutes {
+		otherRoutesCount.WithLabelValues(appName).Inc()
+		return RouteOther
+	}
+
+	routes[route] = true
+
+	return route
+}
+
+// stripQuery removes the query string and fragment from a request path.
+func stripQuery(path string) string {
+	if end := strings.IndexAny(path, "?#"); end >= 0 {
+		path = path[:end]
+	}
+
+	if path == "" {
+		return "/"
+	}
+
+	return path
+}
+
+func templatePattern(template string) string {
+	var pattern strings.Builder
+	pattern.WriteString("^")
+
+	segments := strings.Split(strings.TrimPrefix(template, "/"), "/")
+	for i, segment := range segments {
+		pattern.WriteString("/")
+
+		switch {
+		case segment == "*" && i == len(segments)-1:
+			pattern.WriteString(".*")
+		case strings.HasPrefix(segment, ":"):
+			pattern.WriteString("[^/]+")
+		default:
+			pattern.WriteString(regexp.QuoteMeta(segment))
+		}
+	}
+
+	pattern.WriteString("/?$")
+
+	return pattern.String()
+}
+
+func collapsePath(path string) string {
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		switch {
+		case isNumericSegment(segment):
+			segments[i] = routeIDSegment
+		case isUUIDSegment(segment):
+			segments[i] = routeUUIDSegment
+		}
+	}
+
+	return strings.Join(segments, "/")
+}
+
+func isNumericSegment(segment string) bool {
+	if segment == "" {
+		return false
+	}
+
+	for i := 0; i < len(segment); i++ {
+		if segment[i] < '0' || segment[i] > '9' {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isUUIDSegment(segment string) bool {
+	if len(segment) != 36 {
+		return false
+	}
+
+	for i := 0; i < len(segment); i++ {
+		c := segment[i]
+
+		switch i {
+		case 8, 13, 18, 23:
+			if c != '-' {
+				return false
+			}
+		default:
+			if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+				return false
+			}
+		}
+	}
+
+	return true
+}
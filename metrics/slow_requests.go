@@ -0,0 +1,155 @@
+package metrics
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	herokuLog "heroku-logs-exporter/heroku_log"
+)
+
+// The window is split into slowRequestsBuckets buckets, each keeping its own
+// slowest requests, so requests expire one bucket at a time.
+const slowRequestsBuckets = 6
+
+type SlowRequest struct {
+	RequestID      string    `json:"request_id"`
+	Method         string    `json:"method"`
+	Path           string    `json:"path"`
+	Dyno           string    `json:"dyno"`
+	Status         int       `json:"status"`
+	Code           string    `json:"code,omitempty"`
+	ConnectSeconds float64   `json:"connect_seconds"`
+	ServiceSeconds float64   `json:"service_seconds"`
+	Timestamp      time.Time `json:"timestamp"`
+}
+
+type slowRequestsBucket struct {
+	start    time.Time
+	requests []SlowRequest
+}
+
+// SlowRequests keeps the slowest Heroku Router requests of each app seen
+// within a sliding window, so they can be looked up by request_id in logs.
+// Requests are only kept once Configure sets a size and a window.
+type SlowRequests struct {
+	mutex  sync.Mutex
+	size   int
+	window time.Duration
+	apps   map[string][]slowRequestsBucket
+}
+
+func NewSlowRequests() *SlowRequests {
+	return &SlowRequests{apps: make(map[string][]slowRequestsBucket)}
+}
+
+func (s *SlowRequests) Configure(size int, window time.Duration) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.size = size
+	s.window = window
+	s.apps = make(map[string][]slowRequestsBucket)
+}
+
+// Observe must be called only with Heroku Router lines.
+func (s *SlowRequests) Observe(hLog *herokuLog.HerokuLog) {
+	service, ok := hLog.Value("service")
+	if !ok {
+		return
+	}
+
+	status, _ := strconv.Atoi(hLog.ValueOrUnknown("status"))
+	code, _ := hLog.Value("code")
+	request := SlowRequest{
+		RequestID:      hLog.ValueOrUnknown("request_id"),
+		Method:         hLog.ValueOrUnknown("method"),
+		Path:           stripQuery(hLog.ValueOrUnknown("path")),
+		Dyno:           hLog.ValueOrUnknown("dyno"),
+		Status:         status,
+		Code:           code,
+		ConnectSeconds: herokuLog.ParseMillis(hLog.ValueOrUnknown("connect")),
+		ServiceSeconds: herokuLog.ParseMillis(service),
+		Timestamp:      hLog.Timestamp,
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.size <= 0 || s.window <= 0 {
+		return
+	}
+
+	buckets, ok := s.apps[hLog.AppName]
+	if !ok {
+		buckets = make([]slowRequestsBucket, slowRequestsBuckets)
+		s.apps[hLog.AppName] = buckets
+	}
+
+	bucketDuration := s.window / slowRequestsBuckets
+	start := time.Now().Truncate(bucketDuration)
+	bucket := &buckets[int(start.UnixNano()/int64(bucketDuration))%slowRequestsBuckets]
+	if !bucket.start.Equal(start) {
+		bucket.start = start
+		bucket.requests = bucket.requests[:0]
+	}
+
+	bucket.requests = insertSlowRequest(bucket.requests, request, s.size)
+}
+
+// insertSlowRequest keeps requests sorted from the slowest and at most size
+// long.
+func insertSlowRequest(requests []SlowRequest, request SlowRequest, size int) []SlowRequest {
+	i := sort.Search(len(requests), func(i int) bool {
+		return requests[i].ServiceSeconds < request.ServiceSeconds
+	})
+
+	if i >= size {
+		return requests
+	}
+
+	if len(requests) < size {
+		requests = append(requests, SlowRequest{})
+	}
+
+	copy(requests[i+1:], requests[i:])
+	requests[i] = request
+
+	return requests
+}
+
+// Slowest returns the slowest requests of each app within the window, from
+// the slowest, or only of appName unless it is empty. The result is empty for
+// an unknown app.
+func (s *SlowRequests) Slowest(appName string) map[string][]SlowRequest {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	slowest := make(map[string][]SlowRequest, len(s.apps))
+	if s.size <= 0 || s.window <= 0 {
+		return slowest
+	}
+
+	windowStart := time.Now().Add(-s.window)
+	for name, buckets := range s.apps {
+		if appName != "" && name != appName {
+			continue
+		}
+
+		requests := []SlowRequest{}
+		for _, bucket := range buckets {
+			if !bucket.start.After(windowStart) {
+				continue
+			}
+
+			for _, request := range bucket.requests {
+				requests = insertSlowRequest(requests, request, s.size)
+			}
+		}
+
+		slowest[name] = requests
+	}
+
+	return slowest
+}
@@ -0,0 +1,226 @@
+package metrics
+
+import (
+	"sort"
+	"strconv"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	herokuLog "heroku-logs-exporter/heroku_log"
+)
+
+// Each app tracks topPathsCapacityFactor times more paths than it reports, so
+// the estimates of the reported paths are accurate for skewed traffic.
+const topPathsCapacityFactor = 10
+
+var (
+	topPathRequestsDesc = prometheus.NewDesc(
+		"heroku_router_top_path_requests",
+		"Estimated number of requests of the most requested raw paths reported by Heroku Router.",
+		[]string{"app_name", "path"},
+		nil,
+	)
+	topPathServiceDesc = prometheus.NewDesc(
+		"heroku_router_top_path_service_seconds",
+		"Estimated total service duration of the raw paths with the highest total service duration reported by Heroku Router.",
+		[]string{"app_name", "path"},
+		nil,
+	)
+	topPathErrorsDesc = prometheus.NewDesc(
+		"heroku_router_top_path_errors",
+		"Estimated number of errors and 5xx responses of the raw paths with the most errors reported by Heroku Router.",
+		[]string{"app_name", "path"},
+		nil,
+	)
+)
+
+type TopPath struct {
+	Path  string  `json:"path"`
+	Value float64 `json:"value"`
+	// Error is the maximum overestimation of Value.
+	Error float64 `json:"error"`
+}
+
+// spaceSaving keeps approximate heavy hitters of a weighted stream in a fixed
+// number of counters, see Metwally et al., Efficient Computation of Frequent
+// and Top-k Elements in Data Streams. When all counters are taken, the path
+// with the smallest value is replaced and the new path inherits its value.
+type spaceSaving struct {
+	capacity int
+	entries  map[string]*TopPath
+}
+
+func newSpaceSaving(capacity int) *spaceSaving {
+	return &spaceSaving{
+		capacity: capacity,
+		entries:  make(map[string]*TopPath),
+	}
+}
+
+func (s *spaceSaving) Add(path string, weight float64) {
+	if entry, ok := s.entries[path]; ok {
+		entry.Value += weight
+		return
+	}
+
+	if len(s.entries) < s.capacity {
+		s.entries[path] = &TopPath{Path: path, Value: weight}
+		return
+	}
+
+	var min *TopPath
+	for _, entry := range s.entries {
+		if min == nil || entry.Value < min.Value {
+			min = entry
+		}
+	}
+
+	delete(s.entries, min.Path)
+	s.entries[path] = &TopPath{Path: path, Value: min.Value + weight, Error: min.Value}
+}
+
+func (s *spaceSaving) Top(n int) []TopPath {
+	top := make([]TopPath, 0, len(s.entries))
+	for _, entry := range s.entries {
+		top = append(top, *entry)
+	}
+
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Value != top[j].Value {
+			return top[i].Value > top[j].Value
+		}
+
+		return top[i].Path < top[j].Path
+	})
+
+	if len(top) > n {
+		top = top[:n]
+	}
+
+	return top
+}
+
+type appTopPaths struct {
+	requests *spaceSaving
+	service  *spaceSaving
+	errors   *spaceSaving
+}
+
+type AppTopPaths struct {
+	Requests       []TopPath `json:"requests"`
+	ServiceSeconds []TopPath `json:"service_seconds"`
+	Errors         []TopPath `json:"errors"`
+}
+
+// TopPaths tracks the raw paths of each app with the most requests, the
+// highest total service duration and the most errors. Only the top size paths
+// of each app are exposed, so the number of series stays bounded. Nothing is
+// tracked while the size is 0.
+type TopPaths struct {
+	mutex sync.Mutex
+	size  int
+	apps  map[string]*appTopPaths
+}
+
+func NewTopPaths() *TopPaths {
+	t := &TopPaths{apps: make(map[string]*appTopPaths)}
+	prometheus.MustRegister(t)
+
+	return t
+}
+
+func (t *TopPaths) SetSize(size int) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.size = size
+	t.apps = make(map[string]*appTopPaths)
+}
+
+// Observe must be called only with Heroku Router lines.
+func (t *TopPaths) Observe(hLog *herokuLog.HerokuLog) {
+	path, ok := hLog.Value("path")
+	if !ok || !utf8.ValidString(path) {
+		return
+	}
+
+	path = stripQuery(path)
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.size <= 0 {
+		return
+	}
+
+	app, ok := t.apps[hLog.AppName]
+	if !ok {
+		capacity := t.size * topPathsCapacityFactor
+		app = &appTopPaths{newSpaceSaving(capacity), newSpaceSaving(capacity), newSpaceSaving(capacity)}
+		t.apps[hLog.AppName] = app
+	}
+
+	app.requests.Add(path, 1)
+
+	if service, ok := hLog.Value("service"); ok {
+		app.service.Add(path, herokuLog.ParseMillis(service))
+	}
+
+	if isRouterError(hLog) {
+		app.errors.Add(path, 1)
+	}
+}
+
+// Top returns the top paths of each app, or only of appName unless it is
+// empty. The result is empty for an unknown app.
+func (t *TopPaths) Top(appName string) map[string]AppTopPaths {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	top := make(map[string]AppTopPaths, len(t.apps))
+	for name, app := range t.apps {
+		if appName != "" && name != appName {
+			continue
+		}
+
+		top[name] = AppTopPaths{
+			Requests:       app.requests.Top(t.size),
+			ServiceSeconds: app.service.Top(t.size),
+			Errors:         app.errors.Top(t.size),
+		}
+	}
+
+	return top
+}
+
+func (t *TopPaths) Describe(ch chan<- *prometheus.Desc) {
+	ch <- topPathRequestsDesc
+	ch <- topPathServiceDesc
+	ch <- topPathErrorsDesc
+}
+
+func (t *TopPaths) Collect(ch chan<- prometheus.Metric) {
+	for appName, app := range t.Top("") {
+		collectTopPaths(ch, topPathRequestsDesc, appName, app.Requests)
+		collectTopPaths(ch, topPathServiceDesc, appName, app.ServiceSeconds)
+		collectTopPaths(ch, topPathErrorsDesc, appName, app.Errors)
+	}
+}
+
+func collectTopPaths(ch chan<- prometheus.Metric, desc *prometheus.Desc, appName string, top []TopPath) {
+	for _, path := range top {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, path.Value, appName, path.Path)
+	}
+}
+
+// isRouterError reports router lines with an error code or a 5xx status.
+func isRouterError(hLog *herokuLog.HerokuLog) bool {
+	if _, ok := hLog.Value("code"); ok {
+		return true
+	}
+
+	status, err := strconv.Atoi(hLog.ValueOrUnknown("status"))
+	return err == nil && status >= 500
+}
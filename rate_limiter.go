@@ -0,0 +1,259 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"heroku-logs-exporter/metrics"
+)
+
+const (
+	rateLimitActionReject = "reject"
+	rateLimitActionSample = "sample"
+
+	rateLimitKeyAppName    = "app_name"
+	rateLimitKeyDrainToken = "drain_token"
+)
+
+var (
+	throttledLinesCount = metrics.NewCounterVec(
+		"heroku_logs_exporter_throttled_lines_total",
+		"Log lines over the ingestion rate limit.",
+		[]string{"app_name"},
+	)
+	throttledBytesCount = metrics.NewCounterVec(
+		"heroku_logs_exporter_throttled_bytes_total",
+		"Bytes of log lines over the ingestion rate limit.",
+		[]string{"app_name"},
+	)
+	throttledSampledLinesCount = metrics.NewCounterVec(
+		"heroku_logs_exporter_throttled_sampled_lines_total",
+		"Log lines over the ingestion rate limit which were processed anyway as a sample.",
+		[]string{"app_name"},
+	)
+)
+
+type tokenBucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst float64, now time.Time) *tokenBucket {
+	return &tokenBucket{rate, burst, burst, now}
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens += now.Sub(b.last).Seconds() * b.rate
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+
+	b.last = now
+}
+
+// cost caps tokens taken by a single line at the bucket size, otherwise a line
+// larger than the burst could never pass.
+func (b *tokenBucket) cost(tokens float64) float64 {
+	if tokens > b.burst {
+		return b.burst
+	}
+
+	return tokens
+}
+
+func (b *tokenBucket) refund(tokens float64, now time.Time) {
+	b.refill(now)
+
+	b.tokens += tokens
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+}
+
+type rateLimitState struct {
+	lines     *tokenBucket
+	bytes     *tokenBucket
+	throttled int
+	used      time.Time
+}
+
+// rateLimitSpent counts tokens taken by allowed lines, so they can be given
+// back when the lines are not processed after all.
+type rateLimitSpent struct {
+	lines float64
+	bytes float64
+}
+
+// rateLimiter limits lines and bytes per second of each app or drain token
+// using token buckets which hold up to burst worth of the rate. Buckets unused
+// for burst are full again and are dropped.
+type rateLimiter struct {
+	mutex       sync.Mutex
+	linesRate   float64
+	bytesRate   float64
+	burst       time.Duration
+	sampleRatio int
+	states      map[string]*rateLimitState
+	pruned      time.Time
+}
+
+func newRateLimiter(linesRate float64, bytesRate float64, burst time.Duration, action string, sampleRatio int) (*rateLimiter, error) {
+	if linesRate <= 0 && bytesRate <= 0 {
+		return nil, nil
+	}
+
+	switch action {
+	case rateLimitActionReject:
+		sampleRatio = 0
+	case rateLimitActionSample:
+		if sampleRatio < 1 {
+			return nil, fmt.Errorf("sample ratio must be at least 1, got %d", sampleRatio)
+		}
+	default:
+		return nil, fmt.Errorf("unknown rate limit action %q", action)
+	}
+
+	if burst < time.Second {
+		burst = time.Second
+	}
+
+	return &rateLimiter{
+		linesRate:   linesRate,
+		bytesRate:   bytesRate,
+		burst:       burst,
+		sampleRatio: sampleRatio,
+		states:      make(map[string]*rateLimitState),
+	}, nil
+}
+
+// Allow reports whether a log line of size bytes may be processed. Lines over
+// the limit are either rejected, or every sampleRatio-th of them is allowed.
+// Tokens taken by the line are added to spent unless it is nil.
+func (l *rateLimiter) Allow(key string, appName string, size int, spent *rateLimitSpent) bool {
+	if l == nil {
+		return true
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	now := time.Now()
+	l.prune(now)
+
+	state, ok := l.states[key]
+	if !ok {
+		state = &rateLimitState{}
+		if l.linesRate > 0 {
+			state.lines = newTokenBucket(l.linesRate, l.linesRate*l.burst.Seconds(), now)
+		}
+		if l.bytesRate > 0 {
+			state.bytes = newTokenBucket(l.bytesRate, l.bytesRate*l.burst.Seconds(), now)
+		}
+
+		l.states[key] = state
+	}
+
+	state.used = now
+
+	allowed := true
+	linesCost, bytesCost := 0.0, 0.0
+	if state.lines != nil {
+		state.lines.refill(now)
+		linesCost = state.lines.cost(1)
+		allowed = state.lines.tokens >= linesCost
+	}
+	if state.bytes != nil {
+		state.bytes.refill(now)
+		bytesCost = state.bytes.cost(float64(size))
+		allowed = allowed && state.bytes.tokens >= bytesCost
+	}
+
+	if allowed {
+		if state.lines != nil {
+			state.lines.tokens -= linesCost
+		}
+		if state.bytes != nil {
+			state.bytes.tokens -= bytesCost
+		}
+
+		if spent != nil {
+			spent.lines += linesCost
+			spent.bytes += bytesCost
+		}
+
+		return true
+	}
+
+	throttledLinesCount.WithLabelValues(appName).Inc()
+	throttledBytesCount.WithLabelValues(appName).Add(float64(size))
+
+	if l.sampleRatio == 0 {
+		return false
+	}
+
+	state.throttled++
+	if state.throttled%l.sampleRatio != 0 {
+		return false
+	}
+
+	throttledSampledLinesCount.WithLabelValues(appName).Inc()
+	return true
+}
+
+// Refund gives back tokens spent by lines which were allowed but could not be
+// processed.
+func (l *rateLimiter) Refund(key string, spent rateLimitSpent) {
+	if l == nil || spent == (rateLimitSpent{}) {
+		return
+	}
+
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	state, ok := l.states[key]
+	if !ok {
+		return
+	}
+
+	now := time.Now()
+	if state.lines != nil {
+		state.lines.refund(spent.lines, now)
+	}
+	if state.bytes != nil {
+		state.bytes.refund(spent.bytes, now)
+	}
+}
+
+// prune drops states unused for at least burst, their buckets would be full
+// on the next use anyway. It runs at most once per burst.
+func (l *rateLimiter) prune(now time.Time) {
+	if now.Sub(l.pruned) < l.burst {
+		return
+	}
+
+	for key, state := range l.states {
+		if now.Sub(state.used) >= l.burst {
+			delete(l.states, key)
+		}
+	}
+
+	l.pruned = now
+}
+
+// rateLimitKey returns the drain token only when it is registered in the
+// drains file for appName. Any other token is chosen by the client, and a
+// new one would get a bucket of its own.
+func rateLimitKey(appName string, drainToken string) string {
+	if *rateLimitKeyName != rateLimitKeyDrainToken || drains == nil || drainToken == "" {
+		return appName
+	}
+
+	if tokenAppName, ok := drains.AppForDrainToken(drainToken); ok && tokenAppName == appName {
+		return drainToken
+	}
+
+	return appName
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	tests := []struct {
+		name      string
+		linesRate float64
+		bytesRate float64
+		action    string
+		sizes     []int
+		expected  []bool
+	}{
+		{
+			name:      "lines over burst",
+			linesRate: 0.2,
+			action:    rateLimitActionReject,
+			sizes:     []int{10, 10, 10},
+			expected:  []bool{true, true, false},
+		},
+		{
+			name:      "bytes over burst",
+			bytesRate: 10,
+			action:    rateLimitActionReject,
+			sizes:     []int{60, 40, 1},
+			expected:  []bool{true, true, false},
+		},
+		{
+			name:      "line larger than bytes burst",
+			bytesRate: 10,
+			action:    rateLimitActionReject,
+			sizes:     []int{500, 1, 500},
+			expected:  []bool{true, false, false},
+		},
+		{
+			name:      "line rate below one per burst",
+			linesRate: 0.01,
+			action:    rateLimitActionReject,
+			sizes:     []int{10, 10},
+			expected:  []bool{true, false},
+		},
+		{
+			name:      "sampled lines over limit",
+			linesRate: 0.1,
+			action:    rateLimitActionSample,
+			sizes:     []int{10, 10, 10, 10, 10},
+			expected:  []bool{true, false, true, false, true},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			limiter, err := newRateLimiter(test.linesRate, test.bytesRate, 10*time.Second, test.action, 2)
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			for i, size := range test.sizes {
+				if allowed := limiter.Allow("app", "app", size, nil); allowed != test.expected[i] {
+					t.Errorf("line %d of %d bytes: expected allowed %v, got %v", i, size, test.expected[i], allowed)
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimiterRefund(t *testing.T) {
+	limiter, err := newRateLimiter(0, 10, 10*time.Second, rateLimitActionReject, 0)
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	var spent rateLimitSpent
+	if !limiter.Allow("app", "app", 100, &spent) {
+		t.Fatal("expected first line to be allowed")
+	}
+
+	if limiter.Allow("app", "app", 100, nil) {
+		t.Fatal("expected second line to be throttled")
+	}
+
+	limiter.Refund("app", spent)
+
+	if !limiter.Allow("app", "app", 100, nil) {
+		t.Error("expected line to be allowed after refund")
+	}
+}
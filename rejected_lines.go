@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	herokuLog "heroku-logs-exporter/heroku_log"
+	"heroku-logs-exporter/metrics"
+)
+
+const maxRejectedLineLength = 1024
+
+var (
+	rejectedLinesCount = metrics.NewCounterVec(
+		"heroku_logs_exporter_rejected_lines_total",
+		"Log lines that could not be parsed, by reason.",
+		[]string{"app_name", "reason"},
+	)
+	metricGroupPanicsCount = metrics.NewCounterVec(
+		"heroku_logs_exporter_metric_group_panics_total",
+		"Panics recovered while updating a metric group from a log line.",
+		[]string{"group"},
+	)
+)
+
+type rejectedLine struct {
+	Time    time.Time `json:"time"`
+	AppName string    `json:"app_name"`
+	Reason  string    `json:"reason"`
+	Error   string    `json:"error"`
+	Line    string    `json:"line"`
+}
+
+// rejectedLinesRing keeps the most recent rejected lines for debugging.
+type rejectedLinesRing struct {
+	mutex sync.Mutex
+	lines []rejectedLine
+	next  int
+	full  bool
+}
+
+func newRejectedLinesRing(size int) *rejectedLinesRing {
+	return &rejectedLinesRing{lines: make([]rejectedLine, size)}
+}
+
+func (r *rejectedLinesRing) Add(line rejectedLine) {
+	if len(r.lines) == 0 {
+		return
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.lines[r.next] = line
+	r.next = (r.next + 1) % len(r.lines)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// Lines returns rejected lines from the newest to the oldest.
+func (r *rejectedLinesRing) Lines() []rejectedLine {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	count := r.next
+	if r.full {
+		count = len(r.lines)
+	}
+
+	lines := make([]rejectedLine, 0, count)
+	for i := 1; i <= count; i++ {
+		lines = append(lines, r.lines[(r.next-i+len(r.lines))%len(r.lines)])
+	}
+
+	return lines
+}
+
+func (r *rejectedLinesRing) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := json.NewEncoder(w).Encode(r.Lines()); err != nil {
+		log.Printf("Failed to write rejected lines: %v\n", err)
+	}
+}
+
+func rejectReason(err error) string {
+	switch {
+	case errors.Is(err, herokuLog.ErrInvalidFrame):
+		return "invalid_frame"
+	case errors.Is(err, herokuLog.ErrInvalidPriority):
+		return "invalid_priority"
+	case errors.Is(err, herokuLog.ErrInvalidVersion):
+		return "invalid_version"
+	case errors.Is(err, herokuLog.ErrInvalidTimestamp):
+		return "invalid_timestamp"
+	case errors.Is(err, herokuLog.ErrMissingField):
+		return "missing_field"
+	case errors.Is(err, herokuLog.ErrInvalidCLILog):
+		return "invalid_cli_log"
+	}
+
+	return "unknown"
+}
+
+func rejectLine(appName string, line string, err error) {
+	reason := rejectReason(err)
+	rejectedLinesCount.WithLabelValues(appName, reason).Inc()
+
+	if len(line) > maxRejectedLineLength {
+		line = line[:maxRejectedLineLength]
+	}
+
+	rejectedLines.Add(rejectedLine{time.Now(), appName, reason, err.Error(), line})
+}
+
+func updateMetricGroup(group metrics.HerokuMetricGroup, hLog *herokuLog.HerokuLog) {
+	defer func() {
+		if r := recover(); r != nil {
+			groupName := strings.TrimPrefix(fmt.Sprintf("%T", group), "*metrics.")
+			log.Printf("Recovered panic in %s for log line from %s: %v\n", groupName, hLog.AppName, r)
+			metricGroupPanicsCount.WithLabelValues(groupName).Inc()
+		}
+	}()
+
+	group.UpdateFromLog(hLog)
+}
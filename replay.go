@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+
+	herokuLog "heroku-logs-exporter/heroku_log"
+)
+
+// The replay subcommand feeds archived logs through exportedMetrics and
+// writes snapshots of the resulting metrics in OpenMetrics format, timestamped
+// with the log time, for use with `promtool tsdb create-blocks-from openmetrics`.
+
+var (
+	logplexFormatPattern = regexp.MustCompile(`^(<|[0-9]+ <)`)
+
+	replaySkippedMetricPrefixes = []string{"go_", "process_", "promhttp_", "heroku_logs_exporter_"}
+)
+
+const (
+	replayFormatAuto    = "auto"
+	replayFormatLogplex = "logplex"
+	replayFormatCLI     = "cli"
+)
+
+type logSource interface {
+	ReadMessage() (string, error)
+	Parse(appName string, line string) (*herokuLog.HerokuLog, error)
+}
+
+type logplexSource struct {
+	*herokuLog.LogplexReader
+}
+
+func (s *logplexSource) Parse(appName string, line string) (*herokuLog.HerokuLog, error) {
+	return herokuLog.ParseHerokuLog(appName, line)
+}
+
+type cliSource struct {
+	*herokuLog.LogplexReader
+}
+
+func (s *cliSource) ReadMessage() (string, error) {
+	return s.ReadLine()
+}
+
+func (s *cliSource) Parse(appName string, line string) (*herokuLog.HerokuLog, error) {
+	return herokuLog.ParseHerokuCLILog(appName, line)
+}
+
+func newLogSource(format string, reader *bufio.Reader) (logSource, error) {
+	if format == replayFormatAuto {
+		peek, err := reader.Peek(32)
+		if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+			return nil, err
+		}
+
+		format = replayFormatCLI
+		if logplexFormatPattern.Match(peek) {
+			format = replayFormatLogplex
+		}
+	}
+
+	switch format {
+	case replayFormatLogplex:
+		return &logplexSource{herokuLog.NewLogplexReader(reader, 0)}, nil
+	case replayFormatCLI:
+		return &cliSource{herokuLog.NewLogplexReader(reader, 0)}, nil
+	}
+
+	return nil, fmt.Errorf("unknown format %q", format)
+}
+
+// familySpill holds snapshots of a single metric family, encoded as delimited
+// protobuf, in a temporary file.
+type familySpill struct {
+	file    *os.File
+	writer  *bufio.Writer
+	encoder expfmt.Encoder
+}
+
+// metricsSnapshotter spills snapshots to temporary files in dir, so memory
+// does not grow with the number of snapshots. Only one family at a time is
+// read back, when the output is written.
+type metricsSnapshotter struct {
+	interval time.Duration
+	next     time.Time
+	dir      string
+	names    []string
+	spills   map[string]*familySpill
+}
+
+func newMetricsSnapshotter(interval time.Duration) (*metricsSnapshotter, error) {
+	dir, err := os.MkdirTemp("", "heroku-logs-exporter-replay-")
+	if err != nil {
+		return nil, err
+	}
+
+	return &metricsSnapshotter{
+		interval: interval,
+		dir:      dir,
+		spills:   make(map[string]*familySpill),
+	}, nil
+}
+
+// Observe must be called with the time of each log line before it updates
+// metrics, so every snapshot reflects the state at its own timestamp.
+func (s *metricsSnapshotter) Observe(timestamp time.Time) error {
+	if timestamp.IsZero() {
+		return nil
+	}
+
+	if s.next.IsZero() {
+		s.next = timestamp.Truncate(s.interval).Add(s.interval)
+	}
+
+	for !timestamp.Before(s.next) {
+		if err := s.snapshot(s.next); err != nil {
+			return err
+		}
+
+		s.next = s.next.Add(s.interval)
+	}
+
+	return nil
+}
+
+func (s *metricsSnapshotter) Finish() error {
+	if s.next.IsZero() {
+		return nil
+	}
+
+	return s.snapshot(s.next)
+}
+
+func (s *metricsSnapshotter) snapshot(timestamp time.Time) error {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		return err
+	}
+
+	timestampMs := timestamp.UnixNano() / int64(time.Millisecond)
+	for _, family := range families {
+		if isSkippedReplayMetric(family.GetName()) {
+			continue
+		}
+
+		for _, metric := range family.Metric {
+			metric.TimestampMs = &timestampMs
+			dropExemplars(metric)
+		}
+
+		spill, ok := s.spills[family.GetName()]
+		if !ok {
+			file, err := os.Create(filepath.Join(s.dir, family.GetName()))
+			if err != nil {
+				return err
+			}
+
+			writer := bufio.NewWriter(file)
+			spill = &familySpill{file, writer, expfmt.NewEncoder(writer, expfmt.FmtProtoDelim)}
+
+			s.names = append(s.names, family.GetName())
+			s.spills[family.GetName()] = spill
+		}
+
+		if err := spill.encoder.Encode(family); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *metricsSnapshotter) Write(w io.Writer) error {
+	for _, name := range s.names {
+		family, err := s.spills[name].read()
+		if err != nil {
+			return err
+		}
+
+		// Group samples of each series together, keeping them in time order.
+		sort.SliceStable(family.Metric, func(i, j int) bool {
+			return labelsKey(family.Metric[i]) < labelsKey(family.Metric[j])
+		})
+
+		if _, err := expfmt.MetricFamilyToOpenMetrics(w, family); err != nil {
+			return err
+		}
+	}
+
+	_, err := expfmt.FinalizeOpenMetrics(w)
+	return err
+}
+
+// Close removes the temporary files.
+func (s *metricsSnapshotter) Close() error {
+	for _, spill := range s.spills {
+		spill.file.Close()
+	}
+
+	return os.RemoveAll(s.dir)
+}
+
+// read returns all snapshots of the family merged into one.
+func (f *familySpill) read() (*dto.MetricFamily, error) {
+	if err := f.writer.Flush(); err != nil {
+		return nil, err
+	}
+
+	if _, err := f.file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	var family *dto.MetricFamily
+
+	decoder := expfmt.NewDecoder(bufio.NewReader(f.file), expfmt.FmtProtoDelim)
+	for {
+		snapshot := &dto.MetricFamily{}
+		if err := decoder.Decode(snapshot); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%s: %w", f.file.Name(), err)
+		}
+
+		if family == nil {
+			family = snapshot
+			continue
+		}
+
+		family.Metric = append(family.Metric, snapshot.Metric...)
+	}
+
+	return family, nil
+}
+
+// dropExemplars removes exemplars, which are timestamped with the time of the
+// replay rather than the time of the log line.
+func dropExemplars(metric *dto.Metric) {
+	if metric.Counter != nil {
+		metric.Counter.Exemplar = nil
+	}
+
+	if metric.Histogram != nil {
+		for _, bucket := range metric.Histogram.Bucket {
+			bucket.Exemplar = nil
+		}
+	}
+}
+
+func labelsKey(metric *dto.Metric) string {
+	var key strings.Builder
+	for _, label := range metric.Label {
+		key.WriteString(label.GetName())
+		key.WriteByte('=')
+		key.WriteString(label.GetValue())
+		key.WriteByte(0)
+	}
+
+	return key.String()
+}
+
+func isSkippedReplayMetric(name string) bool {
+	for _, prefix := range replaySkippedMetricPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func replayFile(path string, appName string, format string, snapshotter *metricsSnapshotter) error {
+	var file *os.File
+	if path == "-" {
+		file = os.Stdin
+	} else {
+		var err error
+		file, err = os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+	}
+
+	source, err := newLogSource(format, bufio.NewReader(file))
+	if err != nil {
+		return err
+	}
+
+	count := 0
+	failed := 0
+	for {
+		line, err := source.ReadMessage()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+
+		hLog, err := source.Parse(appName, line)
+		if err != nil {
+			failed = failed + 1
+			continue
+		}
+
+		if err := snapshotter.Observe(hLog.Timestamp); err != nil {
+			return err
+		}
+
+		updateMetrics(hLog)
+		count = count + 1
+	}
+
+	log.Printf("Replayed %d log lines from %s, %d lines failed to parse\n", count, path, failed)
+
+	return nil
+}
+
+func runReplay(args []string) error {
+	flags := flag.NewFlagSet("replay", flag.ExitOnError)
+	appName := flags.String("app-name", "", "Application name used as app_name label")
+	format := flags.String("format", replayFormatAuto, "Format of log files: logplex for RFC 5424 syslog or logplex frames, cli for heroku logs command output, auto to detect per file")
+	interval := flags.Duration("interval", time.Minute, "Log time interval between exported metric samples")
+	output := flags.String("output", "-", "File to write OpenMetrics output to, - for stdout")
+	routesFile := flags.String("router.routes-file", "", "JSON file with rules mapping request paths to the route label of Heroku Router histograms")
+	replayMaxRoutes := flags.Int("router.max-routes", 100, "Maximum number of distinct routes per app for paths not matching any rule")
+	replayJoinTTL := flags.Duration("router.join-ttl", time.Minute, "How long by log time router and application log lines wait to be joined by request_id, 0 disables joining")
+	replayJoinMaxPending := flags.Int("router.join-max-pending", 100000, "Maximum number of log lines waiting to be joined by request_id")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s replay [options] file...\n\nFiles are replayed in the given order, - reads from stdin.\n\n", os.Args[0])
+		flags.PrintDefaults()
+	}
+
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("no log files given")
+	}
+
+	if *interval <= 0 {
+		return errors.New("interval must be positive")
+	}
+
+	routes.SetMaxRoutes(*replayMaxRoutes)
+	joiner.Configure(*replayJoinTTL, *replayJoinMaxPending, true)
+	if *routesFile != "" {
+		if err := routes.Load(*routesFile); err != nil {
+			return err
+		}
+	}
+
+	snapshotter, err := newMetricsSnapshotter(*interval)
+	if err != nil {
+		return err
+	}
+	defer snapshotter.Close()
+
+	for _, path := range flags.Args() {
+		if err := replayFile(path, *appName, *format, snapshotter); err != nil {
+			return err
+		}
+	}
+
+	if err := snapshotter.Finish(); err != nil {
+		return err
+	}
+
+	out := os.Stdout
+	if *output != "-" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		out = file
+	}
+
+	writer := bufio.NewWriter(out)
+	if err := snapshotter.Write(writer); err != nil {
+		return err
+	}
+
+	return writer.Flush()
+}
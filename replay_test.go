@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNewLogSource(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   string
+		input    string
+		expected logSource
+	}{
+		{"syslog line", replayFormatAuto, "<158>1 2026-10-18T10:00:00Z host heroku router - at=info\n", &logplexSource{}},
+		{"logplex frame", replayFormatAuto, "12 <158>1 2026", &logplexSource{}},
+		{"cli line", replayFormatAuto, "2026-10-18T10:00:00.000000+00:00 heroku[router]: at=info\n", &cliSource{}},
+		{"empty input", replayFormatAuto, "", &cliSource{}},
+		{"forced format", replayFormatLogplex, "2026-10-18T10:00:00.000000+00:00 app[web.1]: a\n", &logplexSource{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			source, err := newLogSource(test.format, bufio.NewReader(strings.NewReader(test.input)))
+			if err != nil {
+				t.Fatalf("unexpected error %v", err)
+			}
+
+			if reflect.TypeOf(source) != reflect.TypeOf(test.expected) {
+				t.Errorf("expected %T, got %T", test.expected, source)
+			}
+		})
+	}
+
+	if _, err := newLogSource("xml", bufio.NewReader(strings.NewReader(""))); err == nil {
+		t.Error("expected error for unknown format")
+	}
+}
+
+func TestRunReplay(t *testing.T) {
+	dir := t.TempDir()
+
+	logs := strings.Join([]string{
+		`<158>1 2026-10-18T10:00:10Z host heroku router - at=info method=GET path="/a" host=e.com request_id=r1 dyno=web.1 connect=1ms service=5ms status=200 bytes=1`,
+		`<158>1 2026-10-18T10:00:50Z host heroku router - at=info method=GET path="/a" host=e.com request_id=r2 dyno=web.1 connect=1ms service=5ms status=200 bytes=1`,
+		`<999>1 2026-10-18T10:01:00Z host app web.1 - invalid priority`,
+		`<158>1 2026-10-18T10:02:30Z host heroku router - at=info method=GET path="/a" host=e.com request_id=r3 dyno=web.1 connect=1ms service=5ms status=200 bytes=1`,
+	}, "\n")
+
+	input := filepath.Join(dir, "app.log")
+	if err := os.WriteFile(input, []byte(logs), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	output := filepath.Join(dir, "app.om")
+	if err := runReplay([]string{"-app-name", "replay-test", "-interval", "1m", "-output", output, input}); err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	content, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(string(content), "\n"), "\n")
+	if lines[len(lines)-1] != "# EOF" {
+		t.Errorf("expected output to end with # EOF, got %q", lines[len(lines)-1])
+	}
+
+	types := make(map[string]bool)
+	var samples []string
+	for _, line := range lines {
+		if strings.HasPrefix(line, "# TYPE ") {
+			name := strings.Fields(line)[2]
+			if types[name] {
+				t.Errorf("metric family %s is written more than once", name)
+			}
+
+			types[name] = true
+		}
+
+		if strings.Contains(line, " # {") {
+			t.Errorf("expected no exemplars, got %q", line)
+		}
+
+		if strings.HasPrefix(line, `heroku_router_service_duration_seconds_count{app_name="replay-test",`) {
+			samples = append(samples, line[strings.Index(line, "} ")+2:])
+		}
+	}
+
+	// Snapshots at 10:01 and 10:02 precede the last line, the final one at
+	// 10:03 includes it.
+	expected := []string{"2 1.79231766e+09", "2 1.79231772e+09", "3 1.79231778e+09"}
+	if !reflect.DeepEqual(samples, expected) {
+		t.Errorf("expected samples %q, got %q", expected, samples)
+	}
+}
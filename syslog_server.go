@@ -0,0 +1,180 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	herokuLog "heroku-logs-exporter/heroku_log"
+)
+
+// https://devcenter.heroku.com/articles/log-drains#syslog-drains
+
+var (
+	syslogOpenConnections = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "heroku_logs_exporter_syslog_open_connections",
+			Help: "Number of currently open syslog drain connections.",
+		},
+	)
+)
+
+type syslogServer struct {
+	listener         net.Listener
+	drainApps        map[string]string
+	defaultAppName   string
+	idleTimeout      time.Duration
+	maxMessageLength int
+}
+
+// parseDrainApps parses comma separated "drain-token=app-name" pairs.
+func parseDrainApps(value string) (map[string]string, error) {
+	drainApps := make(map[string]string)
+
+	for _, pair := range strings.Split(value, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid drain app mapping %q", pair)
+		}
+
+		drainApps[parts[0]] = parts[1]
+	}
+
+	return drainApps, nil
+}
+
+func listenSyslog(address string, certFile string, keyFile string, reloadInterval time.Duration) (net.Listener, error) {
+	if certFile == "" && keyFile == "" {
+		return net.Listen("tcp", address)
+	}
+
+	certificate, err := newCertificateReloader("syslog", certFile, keyFile, reloadInterval)
+	if err != nil {
+		return nil, err
+	}
+
+	return tls.Listen("tcp", address, certificate.TLSConfig())
+}
+
+func (s *syslogServer) Serve() error {
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Temporary() {
+				log.Printf("Failed to accept syslog connection: %v\n", err)
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+
+			return err
+		}
+
+		go s.handleConnection(conn)
+	}
+}
+
+// The app name is resolved once per connection from the hostname of the
+// first message, which Heroku sets to the drain token for syslog drains.
+func (s *syslogServer) handleConnection(conn net.Conn) {
+	syslogOpenConnections.Inc()
+	defer syslogOpenConnections.Dec()
+	defer conn.Close()
+
+	remoteAddr := conn.RemoteAddr().String()
+	appName := ""
+	limitKey := ""
+	count := 0
+
+	reader := herokuLog.NewLogplexReader(&idleTimeoutConn{conn, s.idleTimeout}, s.maxMessageLength)
+	for {
+		line, err := reader.ReadMessage()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			log.Printf("Closing syslog connection from %s (%s) after %d log lines: %v\n", remoteAddr, appName, count, err)
+			if errors.Is(err, herokuLog.ErrInvalidFrame) {
+				rejectLine(appName, "", err)
+			}
+
+			return
+		}
+
+		if reader.Truncated() {
+			limitViolationsCount.WithLabelValues(appName, "message_length").Inc()
+		}
+
+		hLog, err := herokuLog.ParseHerokuLog(appName, line)
+		if err != nil {
+			log.Printf("Failed to parse log line from %s: %v\n", appName, err)
+			rejectLine(appName, line, err)
+			continue
+		}
+
+		if appName == "" {
+			appName = s.resolveAppName(hLog.Hostname)
+			if appName == "" {
+				log.Printf("Closing syslog connection from %s: unknown drain token %q\n", remoteAddr, hLog.Hostname)
+				drainAuthFailuresCount.WithLabelValues("unknown_drain_token").Inc()
+				return
+			}
+
+			log.Printf("Accepted syslog connection from %s for %s\n", remoteAddr, appName)
+			hLog.AppName = appName
+			limitKey = rateLimitKey(appName, hLog.Hostname)
+		}
+
+		if !logsLimiter.Allow(limitKey, appName, len(line), nil) {
+			continue
+		}
+
+		logsQueue.Enqueue(appName, []*herokuLog.HerokuLog{hLog})
+		count = count + 1
+	}
+
+	log.Printf("Processed %d log lines from syslog connection %s (%s)\n", count, remoteAddr, appName)
+}
+
+func (s *syslogServer) resolveAppName(drainToken string) string {
+	if drains != nil {
+		if appName, ok := drains.AppForDrainToken(drainToken); ok {
+			return appName
+		}
+	}
+
+	if appName, ok := s.drainApps[drainToken]; ok {
+		return appName
+	}
+
+	return s.defaultAppName
+}
+
+type idleTimeoutConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *idleTimeoutConn) Read(b []byte) (int, error) {
+	if c.timeout > 0 {
+		if err := c.Conn.SetReadDeadline(time.Now().Add(c.timeout)); err != nil {
+			return 0, err
+		}
+	}
+
+	return c.Conn.Read(b)
+}
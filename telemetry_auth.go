@@ -0,0 +1,77 @@
+package main
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+)
+
+type bearerToken struct {
+	mutex sync.RWMutex
+	token []byte
+}
+
+func (t *bearerToken) Load(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	token := strings.TrimSpace(string(content))
+	if token == "" {
+		return fmt.Errorf("%s: empty bearer token", path)
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.token = []byte(token)
+
+	return nil
+}
+
+func (t *bearerToken) Check(token string) bool {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	return subtle.ConstantTimeCompare(t.token, []byte(token)) == 1
+}
+
+// telemetryAuthHandler requires either a bearer token or basic auth
+// credentials, matching the authorization and basic_auth options of a
+// Prometheus scrape_config. Requests pass through when neither is configured.
+func telemetryAuthHandler(token *bearerToken, users *htpasswdFile, handler http.Handler) http.Handler {
+	if token == nil && users == nil {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization := r.Header.Get("Authorization")
+
+		if token != nil && strings.HasPrefix(authorization, "Bearer ") {
+			if token.Check(strings.TrimPrefix(authorization, "Bearer ")) {
+				handler.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		if users != nil {
+			if user, password, ok := r.BasicAuth(); ok && users.Check(user, password) {
+				handler.ServeHTTP(w, r)
+				return
+			}
+		}
+
+		log.Printf("Telemetry authentication failed: %s %s from %s\n", r.Method, r.URL.Path, r.RemoteAddr)
+
+		if users != nil {
+			w.Header().Set("WWW-Authenticate", `Basic realm="heroku-logs-exporter"`)
+		}
+
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+	})
+}
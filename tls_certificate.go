@@ -0,0 +1,81 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"sync"
+	"time"
+
+	"heroku-logs-exporter/metrics"
+)
+
+var (
+	tlsCertificateExpiry = metrics.NewGaugeVec(
+		"heroku_logs_exporter_tls_certificate_expiry_timestamp_seconds",
+		"Expiry time of the served TLS certificate as unix timestamp.",
+		[]string{"listener"},
+	)
+)
+
+// certificateReloader serves a certificate loaded from files and loads it
+// again whenever the certificate or key file changes on disk.
+type certificateReloader struct {
+	listener    string
+	certFile    string
+	keyFile     string
+	mutex       sync.RWMutex
+	certificate *tls.Certificate
+}
+
+func newCertificateReloader(listener string, certFile string, keyFile string, reloadInterval time.Duration) (*certificateReloader, error) {
+	c := &certificateReloader{
+		listener: listener,
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+
+	if err := c.Load(); err != nil {
+		return nil, err
+	}
+
+	reload := func(string) error { return c.Load() }
+	watchFile(certFile, reloadInterval, reload)
+	watchFile(keyFile, reloadInterval, reload)
+
+	return c, nil
+}
+
+func (c *certificateReloader) Load() error {
+	certificate, err := tls.LoadX509KeyPair(c.certFile, c.keyFile)
+	if err != nil {
+		return err
+	}
+
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		return err
+	}
+	certificate.Leaf = leaf
+
+	c.mutex.Lock()
+	c.certificate = &certificate
+	c.mutex.Unlock()
+
+	tlsCertificateExpiry.WithLabelValues(c.listener).Set(float64(leaf.NotAfter.Unix()))
+
+	return nil
+}
+
+func (c *certificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	return c.certificate, nil
+}
+
+func (c *certificateReloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: c.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+}